@@ -0,0 +1,146 @@
+package hamt32
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentTransient is a mutable Hamt that is safe for concurrent use by
+// multiple goroutines. It is a simpler alternative to the Ctrie for write
+// heavy loads.
+//
+// The keys of a Hamt are spread evenly across the IndexLimit slots of the
+// root table by the index of their HashVal at depth 0, and each slot's subtree
+// is independent of the others. So a ConcurrentTransient keeps one lock per
+// root slot and modifies the slot's subtree in place, with the HamtTransient
+// code, under that lock. Goroutines only contend when their keys fall in the
+// same root slot.
+type ConcurrentTransient struct {
+	nentries int64
+	tblOpt   int
+	locks    [IndexLimit]sync.RWMutex
+	shards   [IndexLimit]*HamtTransient
+}
+
+// NewConcurrentTransient constructs a new, empty, ConcurrentTransient.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewConcurrentTransient(tblOpt int) *ConcurrentTransient {
+	var c = new(ConcurrentTransient)
+	c.tblOpt = tblOpt
+	for i := range c.shards {
+		c.shards[i] = NewTransient(tblOpt)
+		// the shards are shared by design, under the locks
+		c.shards[i].guard = nil
+	}
+	return c
+}
+
+// IsEmpty returns if the ConcurrentTransient has no entries.
+func (c *ConcurrentTransient) IsEmpty() bool {
+	return c.Nentries() == 0
+}
+
+// Nentries returns the number of (key,value) pairs in the
+// ConcurrentTransient.
+func (c *ConcurrentTransient) Nentries() uint {
+	return uint(atomic.LoadInt64(&c.nentries))
+}
+
+// Get retrieves the value related to the key in the ConcurrentTransient. It
+// returns the value and true if the key was found, or nil and false if not.
+func (c *ConcurrentTransient) Get(key KeyI) (interface{}, bool) {
+	var idx = key.Hash().Index(0)
+
+	c.locks[idx].RLock()
+	var val, found = c.shards[idx].Get(key)
+	c.locks[idx].RUnlock()
+
+	return val, found
+}
+
+// Put stores a new (key,value) pair in the ConcurrentTransient. It returns
+// true if the key was added, or false if the value of an existing key was
+// replaced.
+func (c *ConcurrentTransient) Put(key KeyI, val interface{}) bool {
+	var idx = key.Hash().Index(0)
+
+	c.locks[idx].Lock()
+	var _, added = c.shards[idx].Put(key, val)
+	c.locks[idx].Unlock()
+
+	if added {
+		atomic.AddInt64(&c.nentries, 1)
+	}
+	return added
+}
+
+// Del removes the key from the ConcurrentTransient. It returns the value of
+// the key and true if it was found, or nil and false if not.
+func (c *ConcurrentTransient) Del(key KeyI) (interface{}, bool) {
+	var idx = key.Hash().Index(0)
+
+	c.locks[idx].Lock()
+	var _, val, deleted = c.shards[idx].Del(key)
+	c.locks[idx].Unlock()
+
+	if deleted {
+		atomic.AddInt64(&c.nentries, -1)
+	}
+	return val, deleted
+}
+
+// Range executes the given function for every KeyVal pair in the
+// ConcurrentTransient, in Range() order, until fn returns false.
+//
+// The KeyVal pairs of each root slot's subtree are collected under that
+// slot's read lock, so Range is consistent per subtree but not across
+// subtrees. fn is called after the lock is released, so it may use the
+// ConcurrentTransient, Put() and Del() included.
+func (c *ConcurrentTransient) Range(fn func(KeyI, interface{}) bool) {
+	var kvs []KeyVal
+	for idx := range c.shards {
+		kvs = kvs[:0]
+		c.locks[idx].RLock()
+		if !c.shards[idx].IsEmpty() {
+			c.shards[idx].walk(func(n nodeI) bool {
+				if l, isLeaf := n.(leafI); isLeaf {
+					kvs = append(kvs, l.keyVals()...)
+				}
+				return true
+			})
+		}
+		c.locks[idx].RUnlock()
+
+		for _, kv := range kvs {
+			if !fn(kv.Key, kv.Val) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot returns a copy of the ConcurrentTransient as a HamtFunctional.
+// Each root slot's subtree is copied under that slot's read lock, so the
+// Snapshot is consistent per subtree but not across subtrees.
+func (c *ConcurrentTransient) Snapshot() *HamtFunctional {
+	var copies = make([]Hamt, 0, IndexLimit)
+	for idx := range c.shards {
+		c.locks[idx].RLock()
+		if !c.shards[idx].IsEmpty() {
+			copies = append(copies, c.shards[idx].DeepCopy())
+		}
+		c.locks[idx].RUnlock()
+	}
+
+	if len(copies) == 0 {
+		return NewFunctional(c.tblOpt)
+	}
+
+	var h, err = Join(copies...)
+	_ = assertOn && assertf(err == nil,
+		"ConcurrentTransient.Snapshot(): Join() => %s", err)
+
+	return h.(*HamtFunctional)
+}
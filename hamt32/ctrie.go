@@ -0,0 +1,861 @@
+package hamt32
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Ctrie is a concurrent, lock-free, Hamt based on "Concurrent Tries with
+// Efficient Non-Blocking Snapshots" by Prokopec, Bronson, Bagwell, and
+// Odersky. It is safe for any number of goroutines to Get(), Put(), and Del()
+// at the same time.
+//
+// Every table of a Ctrie, except the root table, hangs off an indirection
+// node (iNode). The tables (the paper's CNodes) are the same fixedTable and
+// sparseTable used by the Hamt and they are never modified once published;
+// Put() and Del() copy the table, modify the copy, and compare-and-swap the
+// copy into the table's iNode. So two goroutines only conflict when they
+// modify the same table.
+//
+// Snapshot() is O(1). Each iNode is stamped with the generation it was
+// created in. Taking a Snapshot() swaps the root iNode for a copy in a new
+// generation, which freezes every iNode of the old generation; writers copy
+// old iNodes into the new generation lazily, on their way down the tree.
+//
+// The Ctrie keeps no count of its entries, since every Put() and Del() would
+// have to update it; instead each generation counts the entries its writes
+// add and remove, which gives a CtrieSnapshot an O(1) Nentries().
+//
+// The compare-and-swap of a table into an iNode is the paper's GCAS, which
+// only commits if the Ctrie's generation has not changed since the
+// operation started, and the swap of the root iNode is the paper's RDCSS.
+type Ctrie struct {
+	root       unsafe.Pointer // *iNode
+	readOnly   bool
+	tblOpt     int
+	nograde    bool
+	startFixed bool
+}
+
+// generation is mostly compared by pointer, but it also counts the entries of
+// the Ctrie. Every Put() and Del() that commits in a generation adds to its
+// delta, so once a Snapshot() has frozen a generation, the number of entries
+// as of its end is the number as of the end of its base, plus its delta.
+type generation struct {
+	// delta and pending are first so that they are 64 bit aligned, as atomic
+	// requires, on 32 bit platforms too.
+	delta   int64 // entries added less entries removed; atomic
+	pending int64 // Put()s and Del()s in progress; atomic
+
+	base     *generation
+	lock     sync.Mutex // protects base, settled, and nentries
+	settled  bool
+	nentries uint
+}
+
+// settle returns the number of entries as of the end of a frozen generation,
+// and true. The writes still in progress in the generation can no longer
+// commit, but their delta may not have been added yet; if wait is false,
+// settle returns false rather than wait for them.
+//
+// Once settled, a generation drops its base, so the chain of generations
+// behind a Ctrie does not grow with every Snapshot().
+func (g *generation) settle(wait bool) (uint, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.settled {
+		return g.nentries, true
+	}
+
+	var n int64
+	if g.base != nil {
+		var nents, ok = g.base.settle(wait)
+		if !ok {
+			return 0, false
+		}
+		n = int64(nents)
+	}
+
+	if atomic.LoadInt64(&g.pending) != 0 {
+		if !wait {
+			return 0, false
+		}
+		for atomic.LoadInt64(&g.pending) != 0 {
+			runtime.Gosched()
+		}
+	}
+
+	g.nentries = uint(n + atomic.LoadInt64(&g.delta))
+	g.settled = true
+	g.base = nil
+
+	return g.nentries, true
+}
+
+// iNode is the indirection node the Ctrie compare-and-swaps tables into. The
+// root iNode of a Ctrie doubles as the RDCSS descriptor when rdcss is set.
+type iNode struct {
+	main  unsafe.Pointer // *mainNode
+	gen   *generation
+	rdcss *rdcssDescriptor
+}
+
+// mainNode is what an iNode points to: a table, or a tomb holding the single
+// leaf a table has been contracted to, or (only as a mainNode's prev) a marker
+// that a GCAS failed and must be rolled back to failed.
+type mainNode struct {
+	table  tableI
+	tomb   leafI
+	failed *mainNode
+	prev   unsafe.Pointer // *mainNode
+}
+
+type rdcssDescriptor struct {
+	old       *iNode
+	expected  *mainNode
+	nv        *iNode
+	committed int32
+}
+
+// NewCtrie constructs a new, empty, Ctrie.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewCtrie(tblOpt int) *Ctrie {
+	var c = new(Ctrie)
+	c.tblOpt = tblOpt
+	switch tblOpt {
+	case SparseTables:
+		c.nograde = true
+	case FixedTables:
+		c.nograde = true
+		c.startFixed = true
+	}
+
+	var root = &iNode{gen: new(generation)}
+	root.main = unsafe.Pointer(&mainNode{table: new(fixedTable)})
+	c.root = unsafe.Pointer(root)
+
+	return c
+}
+
+// Hash returns the hashPath of the table the iNode currently points to.
+func (in *iNode) Hash() HashVal {
+	var m = (*mainNode)(atomic.LoadPointer(&in.main))
+	if m.tomb != nil {
+		return m.tomb.Hash()
+	}
+	return m.table.Hash()
+}
+
+// String returns a string representation of the iNode.
+func (in *iNode) String() string {
+	var m = (*mainNode)(atomic.LoadPointer(&in.main))
+	if m.tomb != nil {
+		return fmt.Sprintf("iNode{tomb: %s}", m.tomb)
+	}
+	return fmt.Sprintf("iNode{%s}", m.table)
+}
+
+// visit is transparent; it visits whatever the iNode currently points to. It
+// does not complete or roll back a pending GCAS, so it is only fit for
+// debugging output.
+func (in *iNode) visit(fn visitFn) bool {
+	var m = (*mainNode)(atomic.LoadPointer(&in.main))
+	if m.tomb != nil {
+		return m.tomb.visit(fn)
+	}
+	return m.table.visit(fn)
+}
+
+// copyToGen returns a copy of the iNode in the given generation.
+func (in *iNode) copyToGen(gen *generation, c *Ctrie) *iNode {
+	var nin = &iNode{gen: gen}
+	nin.main = unsafe.Pointer(c.gcasRead(in))
+	return nin
+}
+
+//
+// GCAS
+//
+
+func (c *Ctrie) gcas(in *iNode, old, n *mainNode) bool {
+	atomic.StorePointer(&n.prev, unsafe.Pointer(old))
+	if atomic.CompareAndSwapPointer(&in.main,
+		unsafe.Pointer(old), unsafe.Pointer(n)) {
+		c.gcasComplete(in, n)
+		return atomic.LoadPointer(&n.prev) == nil
+	}
+	return false
+}
+
+func (c *Ctrie) gcasRead(in *iNode) *mainNode {
+	var m = (*mainNode)(atomic.LoadPointer(&in.main))
+	if atomic.LoadPointer(&m.prev) == nil {
+		return m
+	}
+	return c.gcasComplete(in, m)
+}
+
+func (c *Ctrie) gcasComplete(in *iNode, m *mainNode) *mainNode {
+	for {
+		var prev = (*mainNode)(atomic.LoadPointer(&m.prev))
+		var root = c.rdcssReadRoot(true)
+		if prev == nil {
+			return m
+		}
+
+		if prev.failed != nil {
+			// roll back to the mainNode the failed GCAS replaced
+			if atomic.CompareAndSwapPointer(&in.main,
+				unsafe.Pointer(m), unsafe.Pointer(prev.failed)) {
+				return prev.failed
+			}
+			m = (*mainNode)(atomic.LoadPointer(&in.main))
+			continue
+		}
+
+		if root.gen == in.gen && !c.readOnly {
+			// commit
+			if atomic.CompareAndSwapPointer(&m.prev,
+				unsafe.Pointer(prev), nil) {
+				return m
+			}
+			continue
+		}
+
+		// a Snapshot() was taken since the GCAS started; fail it
+		atomic.CompareAndSwapPointer(&m.prev,
+			unsafe.Pointer(prev), unsafe.Pointer(&mainNode{failed: prev}))
+		m = (*mainNode)(atomic.LoadPointer(&in.main))
+	}
+}
+
+//
+// RDCSS
+//
+
+func (c *Ctrie) readRoot() *iNode {
+	return c.rdcssReadRoot(false)
+}
+
+func (c *Ctrie) rdcssReadRoot(abort bool) *iNode {
+	var r = (*iNode)(atomic.LoadPointer(&c.root))
+	if r.rdcss != nil {
+		return c.rdcssComplete(abort)
+	}
+	return r
+}
+
+func (c *Ctrie) rdcssRoot(old *iNode, expected *mainNode, nv *iNode) bool {
+	var desc = &iNode{
+		rdcss: &rdcssDescriptor{old: old, expected: expected, nv: nv},
+	}
+	if atomic.CompareAndSwapPointer(&c.root,
+		unsafe.Pointer(old), unsafe.Pointer(desc)) {
+		c.rdcssComplete(false)
+		return atomic.LoadInt32(&desc.rdcss.committed) == 1
+	}
+	return false
+}
+
+func (c *Ctrie) rdcssComplete(abort bool) *iNode {
+	for {
+		var r = (*iNode)(atomic.LoadPointer(&c.root))
+		if r.rdcss == nil {
+			return r
+		}
+
+		var desc = r.rdcss
+		if abort {
+			if atomic.CompareAndSwapPointer(&c.root,
+				unsafe.Pointer(r), unsafe.Pointer(desc.old)) {
+				return desc.old
+			}
+			continue
+		}
+
+		if c.gcasRead(desc.old) == desc.expected {
+			if atomic.CompareAndSwapPointer(&c.root,
+				unsafe.Pointer(r), unsafe.Pointer(desc.nv)) {
+				atomic.StoreInt32(&desc.committed, 1)
+				return desc.nv
+			}
+			continue
+		}
+
+		if atomic.CompareAndSwapPointer(&c.root,
+			unsafe.Pointer(r), unsafe.Pointer(desc.old)) {
+			return desc.old
+		}
+	}
+}
+
+//
+// Tables
+//
+
+// newTable returns a new, empty, table for the given depth and hashPath.
+func (c *Ctrie) newTable(depth uint, hashPath HashVal) tableI {
+	if c.startFixed {
+		var ft = new(fixedTable)
+		ft.depth = depth
+		ft.hashPath = hashPath
+		return ft
+	}
+	var st = new(sparseTable)
+	st.depth = depth
+	st.hashPath = hashPath
+	st.nodes = make([]nodeI, 0, sparseTableInitCap)
+	return st
+}
+
+// inserted returns a copy of t with n inserted at idx.
+func (c *Ctrie) inserted(t tableI, depth, idx uint, n nodeI) tableI {
+	var nt tableI
+	if _, isSparse := t.(*sparseTable); isSparse &&
+		!c.nograde && t.nentries()+1 == UpgradeThreshold {
+		nt = upgradeToFixedTable(t.Hash(), depth, t.entries())
+	} else {
+		nt = t.copy()
+	}
+	nt.insert(idx, n)
+	return nt
+}
+
+// replaced returns a copy of t with n replacing the node at idx.
+func (c *Ctrie) replaced(t tableI, idx uint, n nodeI) tableI {
+	var nt = t.copy()
+	nt.replace(idx, n)
+	return nt
+}
+
+// removed returns a copy of t with the node at idx removed.
+func (c *Ctrie) removed(t tableI, depth, idx uint) tableI {
+	if _, isFixed := t.(*fixedTable); isFixed &&
+		depth > 0 && !c.nograde && t.nentries()-1 == DowngradeThreshold {
+		var ents = t.entries()
+		for i, ent := range ents {
+			if ent.idx == idx {
+				ents = append(ents[:i], ents[i+1:]...)
+				break
+			}
+		}
+		return downgradeToSparseTable(t.Hash(), depth, ents)
+	}
+	var nt = t.copy()
+	nt.remove(idx)
+	return nt
+}
+
+// dual returns the node for a slot, at depth-1, holding both leafs; that is
+// an iNode for a table at depth or, if there are no more levels, one leaf.
+func (c *Ctrie) dual(l1, l2 leafI, depth uint, gen *generation) nodeI {
+	if depth > maxDepth {
+		return mergeLeafs(l1, l2)
+	}
+
+	var t = c.newTable(depth, l1.Hash().hashPath(depth))
+	var idx1 = l1.Hash().Index(depth)
+	var idx2 = l2.Hash().Index(depth)
+	if idx1 != idx2 {
+		t.insert(idx1, l1)
+		t.insert(idx2, l2)
+	} else {
+		t.insert(idx1, c.dual(l1, l2, depth+1, gen))
+	}
+
+	var in = &iNode{gen: gen}
+	in.main = unsafe.Pointer(&mainNode{table: t})
+	return in
+}
+
+// renewed returns a copy of the mainNode m with every child iNode copied into
+// the given generation.
+func (c *Ctrie) renewed(m *mainNode, gen *generation) *mainNode {
+	var nt = m.table.copy()
+	for _, ent := range nt.entries() {
+		if in, isINode := ent.node.(*iNode); isINode {
+			nt.replace(ent.idx, in.copyToGen(gen, c))
+		}
+	}
+	return &mainNode{table: nt}
+}
+
+// contracted returns the mainNode for t; a tomb if t, below the root, is down
+// to a single leaf.
+func contracted(t tableI, depth uint) *mainNode {
+	if depth > 0 && t.nentries() == 1 {
+		var ents = t.entries()
+		if l, isLeaf := ents[0].node.(leafI); isLeaf {
+			return &mainNode{tomb: l}
+		}
+	}
+	return &mainNode{table: t}
+}
+
+// compressed returns the mainNode for t with every child iNode that holds a
+// tomb replaced by the tomb's leaf.
+func (c *Ctrie) compressed(t tableI, depth uint) *mainNode {
+	var nt = t.copy()
+	for _, ent := range nt.entries() {
+		if in, isINode := ent.node.(*iNode); isINode {
+			if m := c.gcasRead(in); m.tomb != nil {
+				nt.replace(ent.idx, m.tomb)
+			}
+		}
+	}
+	return contracted(nt, depth)
+}
+
+// clean compresses the table of the iNode at depth.
+func (c *Ctrie) clean(in *iNode, depth uint) {
+	var m = c.gcasRead(in)
+	if m.table != nil {
+		c.gcas(in, m, c.compressed(m.table, depth))
+	}
+}
+
+// cleanParent replaces in, whose table has been contracted to a tomb, with
+// the tomb's leaf in its parent's table at depth.
+func (c *Ctrie) cleanParent(
+	parent, in *iNode,
+	hv HashVal,
+	depth uint,
+	startGen *generation,
+) {
+	for {
+		var m = c.gcasRead(in)
+		var pm = c.gcasRead(parent)
+		if pm.table == nil || m.tomb == nil {
+			return
+		}
+
+		var idx = hv.Index(depth)
+		if child, isINode := pm.table.get(idx).(*iNode); !isINode ||
+			child != in {
+			return
+		}
+
+		var npm = contracted(c.replaced(pm.table, idx, m.tomb), depth)
+		if c.gcas(parent, pm, npm) || c.readRoot().gen != startGen {
+			return
+		}
+	}
+}
+
+//
+// Operations
+//
+
+// Get retrieves the value related to the key in the Ctrie. It returns the
+// value and true if the key was found, or nil and false if not.
+func (c *Ctrie) Get(key KeyI) (interface{}, bool) {
+	var hv = key.Hash()
+	for {
+		var r = c.readRoot()
+		if val, found, ok := c.lookup(r, key, hv, 0, nil, r.gen); ok {
+			return val, found
+		}
+	}
+}
+
+func (c *Ctrie) lookup(
+	in *iNode,
+	key KeyI,
+	hv HashVal,
+	depth uint,
+	parent *iNode,
+	startGen *generation,
+) (val interface{}, found bool, ok bool) {
+	var m = c.gcasRead(in)
+
+	if m.tomb != nil {
+		if c.readOnly {
+			val, found = m.tomb.get(key)
+			return val, found, true
+		}
+		c.clean(parent, depth-1)
+		return nil, false, false
+	}
+
+	switch x := m.table.get(hv.Index(depth)).(type) {
+	case nil:
+		return nil, false, true
+	case *iNode:
+		if c.readOnly || x.gen == startGen {
+			return c.lookup(x, key, hv, depth+1, in, startGen)
+		}
+		if c.gcas(in, m, c.renewed(m, startGen)) {
+			return c.lookup(in, key, hv, depth, parent, startGen)
+		}
+		return nil, false, false
+	case leafI:
+		val, found = x.get(key)
+		return val, found, true
+	}
+
+	panic("Ctrie.lookup: unknown node type")
+}
+
+// Put stores a new (key,value) pair in the Ctrie. It returns true if the key
+// was added, or false if the value of an existing key was replaced.
+func (c *Ctrie) Put(key KeyI, val interface{}) bool {
+	if c.readOnly {
+		panic("Ctrie.Put: the Ctrie is a read-only snapshot")
+	}
+
+	var hv = key.Hash()
+	for {
+		var r = c.readRoot()
+		atomic.AddInt64(&r.gen.pending, 1)
+		var added, ok = c.insert(r, key, val, hv, 0, nil, r.gen)
+		if ok && added {
+			atomic.AddInt64(&r.gen.delta, 1)
+		}
+		atomic.AddInt64(&r.gen.pending, -1)
+		if ok {
+			return added
+		}
+	}
+}
+
+func (c *Ctrie) insert(
+	in *iNode,
+	key KeyI,
+	val interface{},
+	hv HashVal,
+	depth uint,
+	parent *iNode,
+	startGen *generation,
+) (added bool, ok bool) {
+	var m = c.gcasRead(in)
+
+	if m.tomb != nil {
+		c.clean(parent, depth-1)
+		return false, false
+	}
+
+	var idx = hv.Index(depth)
+	var nt tableI
+	switch x := m.table.get(idx).(type) {
+	case nil:
+		nt = c.inserted(m.table, depth, idx, newFlatLeaf(key, val))
+		added = true
+	case *iNode:
+		if x.gen == startGen {
+			return c.insert(x, key, val, hv, depth+1, in, startGen)
+		}
+		if c.gcas(in, m, c.renewed(m, startGen)) {
+			return c.insert(in, key, val, hv, depth, parent, startGen)
+		}
+		return false, false
+	case leafI:
+		var node nodeI
+		if x.Hash() == hv {
+			node, added = x.put(key, val)
+		} else {
+			node = c.dual(x, newFlatLeaf(key, val), depth+1, startGen)
+			added = true
+		}
+		nt = c.replaced(m.table, idx, node)
+	}
+
+	return added, c.gcas(in, m, &mainNode{table: nt})
+}
+
+// Del removes the key from the Ctrie. It returns the value of the key and
+// true if it was found, or nil and false if not.
+func (c *Ctrie) Del(key KeyI) (interface{}, bool) {
+	if c.readOnly {
+		panic("Ctrie.Del: the Ctrie is a read-only snapshot")
+	}
+
+	var hv = key.Hash()
+	for {
+		var r = c.readRoot()
+		atomic.AddInt64(&r.gen.pending, 1)
+		var val, found, ok = c.remove(r, key, hv, 0, nil, r.gen)
+		if ok && found {
+			atomic.AddInt64(&r.gen.delta, -1)
+		}
+		atomic.AddInt64(&r.gen.pending, -1)
+		if ok {
+			return val, found
+		}
+	}
+}
+
+func (c *Ctrie) remove(
+	in *iNode,
+	key KeyI,
+	hv HashVal,
+	depth uint,
+	parent *iNode,
+	startGen *generation,
+) (val interface{}, found bool, ok bool) {
+	var m = c.gcasRead(in)
+
+	if m.tomb != nil {
+		c.clean(parent, depth-1)
+		return nil, false, false
+	}
+
+	var idx = hv.Index(depth)
+	switch x := m.table.get(idx).(type) {
+	case nil:
+		return nil, false, true
+	case *iNode:
+		if x.gen == startGen {
+			return c.remove(x, key, hv, depth+1, in, startGen)
+		}
+		if c.gcas(in, m, c.renewed(m, startGen)) {
+			return c.remove(in, key, hv, depth, parent, startGen)
+		}
+		return nil, false, false
+	case leafI:
+		var nl leafI
+		nl, val, found = x.del(key)
+		if !found {
+			return nil, false, true
+		}
+
+		var nt tableI
+		if nl == nil {
+			nt = c.removed(m.table, depth, idx)
+		} else {
+			nt = c.replaced(m.table, idx, nl)
+		}
+
+		if !c.gcas(in, m, contracted(nt, depth)) {
+			return nil, false, false
+		}
+		if parent != nil && c.gcasRead(in).tomb != nil {
+			c.cleanParent(parent, in, hv, depth-1, startGen)
+		}
+		return val, true, true
+	}
+
+	panic("Ctrie.remove: unknown node type")
+}
+
+// Snapshot returns a read-only snapshot of the Ctrie in O(1). The Ctrie may
+// continue to be modified by any number of goroutines; the snapshot never
+// changes.
+func (c *Ctrie) Snapshot() *CtrieSnapshot {
+	if c.readOnly {
+		return &CtrieSnapshot{c}
+	}
+
+	for {
+		var r = c.readRoot()
+		var m = c.gcasRead(r)
+		var gen = &generation{base: r.gen}
+		if c.rdcssRoot(r, m, r.copyToGen(gen, c)) {
+			// count the generations frozen by earlier Snapshot()s, so they
+			// can be dropped
+			r.gen.settle(false)
+
+			var sc = &Ctrie{
+				root:       unsafe.Pointer(r),
+				readOnly:   true,
+				tblOpt:     c.tblOpt,
+				nograde:    c.nograde,
+				startFixed: c.startFixed,
+			}
+			return &CtrieSnapshot{sc}
+		}
+	}
+}
+
+// Range executes the given function for every KeyVal pair of a Snapshot() of
+// the Ctrie, in Range() order, until fn returns false.
+//
+// Like any Snapshot(), it starts a new generation, so the writers copy every
+// table they modify afterwards once more. To Range() over the Ctrie more than
+// once without modifying it, Range() over a single Snapshot() instead.
+func (c *Ctrie) Range(fn func(KeyI, interface{}) bool) {
+	c.Snapshot().Range(fn)
+}
+
+// resolve returns what the iNode points to: a table, or the leaf of a tomb.
+func (c *Ctrie) resolve(in *iNode) nodeI {
+	var m = c.gcasRead(in)
+	if m.tomb != nil {
+		return m.tomb
+	}
+	return m.table
+}
+
+// CtrieSnapshot is the immutable result of Ctrie.Snapshot(). It is a Hamt,
+// and behaves like a HamtFunctional: Put() and Del() return a new
+// CtrieSnapshot, which shares all but the modified tables with the original.
+// Both are O(1) plus the cost of the same Put() or Del() on a Ctrie.
+//
+// Its tables hold iNodes in place of their child tables, so it can not share
+// them with a HamtFunctional or a HamtTransient; ToTransient() is O(n).
+type CtrieSnapshot struct {
+	ctrie *Ctrie
+}
+
+// IsEmpty returns true if the CtrieSnapshot holds no entries.
+func (s *CtrieSnapshot) IsEmpty() bool {
+	return s.Nentries() == 0
+}
+
+// Nentries returns the number of (key,value) pairs in the CtrieSnapshot. It
+// is O(1), but the first call may have to wait for the Put()s and Del()s that
+// were in progress when the Snapshot() was taken.
+func (s *CtrieSnapshot) Nentries() uint {
+	var n, _ = s.ctrie.readRoot().gen.settle(true)
+	return n
+}
+
+// ToFunctional returns the CtrieSnapshot itself; it already behaves like a
+// HamtFunctional.
+func (s *CtrieSnapshot) ToFunctional() Hamt {
+	return s
+}
+
+// ToTransient copies the CtrieSnapshot into a new HamtTransient, with the same
+// table option as the Ctrie, using a Builder. It is O(n).
+func (s *CtrieSnapshot) ToTransient() Hamt {
+	var b = NewBuilder(s.ctrie.tblOpt)
+	s.Range(func(k KeyI, v interface{}) bool {
+		b.Add(k, v)
+		return true
+	})
+	return b.Build().ToTransient()
+}
+
+// DeepCopy returns the CtrieSnapshot itself; nothing can modify it, so a copy
+// would be indistinguishable.
+func (s *CtrieSnapshot) DeepCopy() Hamt {
+	return s
+}
+
+// Get retrieves the value related to the key in the CtrieSnapshot. It returns
+// the value and true if the key was found, or nil and false if not.
+func (s *CtrieSnapshot) Get(key KeyI) (interface{}, bool) {
+	return s.ctrie.Get(key)
+}
+
+// Put returns a new CtrieSnapshot with the (key,value) pair stored in it, and
+// true if the key was added, or false if the value of an existing key was
+// replaced.
+func (s *CtrieSnapshot) Put(key KeyI, val interface{}) (Hamt, bool) {
+	var added bool
+	var ns = s.modify(func(c *Ctrie) {
+		added = c.Put(key, val)
+	})
+	return ns, added
+}
+
+// Del returns a new CtrieSnapshot without the key, the value of the key, and
+// true if it was found; or the CtrieSnapshot itself, nil, and false if not.
+func (s *CtrieSnapshot) Del(key KeyI) (Hamt, interface{}, bool) {
+	if _, found := s.Get(key); !found {
+		return s, nil, false
+	}
+
+	var val interface{}
+	var ns = s.modify(func(c *Ctrie) {
+		val, _ = c.Del(key)
+	})
+	return ns, val, true
+}
+
+// modify applies fn to a private, writable, copy of the CtrieSnapshot, made
+// in O(1) the same way Snapshot() makes one, and returns the copy frozen into
+// a new CtrieSnapshot.
+func (s *CtrieSnapshot) modify(fn func(*Ctrie)) *CtrieSnapshot {
+	var r = s.ctrie.readRoot()
+	var c = *s.ctrie
+	c.root = unsafe.Pointer(r.copyToGen(&generation{base: r.gen}, s.ctrie))
+	c.readOnly = false
+	fn(&c)
+	c.readOnly = true
+	return &CtrieSnapshot{&c}
+}
+
+// String returns a string representation of the CtrieSnapshot.
+func (s *CtrieSnapshot) String() string {
+	return fmt.Sprintf("CtrieSnapshot{ nentries: %d, root: %s }",
+		s.Nentries(), s.rootView().node)
+}
+
+// LongString returns a complete recursive listing of the CtrieSnapshot, one
+// NodeView per line.
+func (s *CtrieSnapshot) LongString(indent string) string {
+	var str = indent +
+		fmt.Sprintf("CtrieSnapshot{ nentries: %d, root:\n", s.Nentries())
+	s.Walk(PreOrder, func(v NodeView) bool {
+		str += indent + strings.Repeat("  ", int(v.Depth())+1) +
+			v.String() + "\n"
+		return true
+	})
+	str += indent + "} //CtrieSnapshot"
+	return str
+}
+
+// Range executes the given function for every KeyVal pair in the
+// CtrieSnapshot, in Range() order, until fn returns false.
+func (s *CtrieSnapshot) Range(fn func(KeyI, interface{}) bool) {
+	s.Walk(LeafsOnly, func(v NodeView) bool {
+		for _, kv := range v.KeyVals() {
+			if !fn(kv.Key, kv.Val) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Stats walks the CtrieSnapshot and returns a Stats data structure of it.
+func (s *CtrieSnapshot) Stats() *Stats {
+	var stats = new(Stats)
+	var keyVals uint
+	s.rootView().walk(PreOrder, func(v nodeView) bool {
+		stats.addNode(v.node)
+		if size, isCollision := collisionSize(v.node); isCollision &&
+			size > stats.MaxCollisionLeafSize {
+			stats.MaxCollisionLeafSize = size
+		}
+		if v.IsLeaf() {
+			keyVals += v.Nentries()
+		}
+		return true
+	})
+	stats.addRoot(s.Root().Nentries(), keyVals)
+	return stats
+}
+
+// QuickStats returns the same as Stats(); a CtrieSnapshot does not keep its
+// Stats up to date, so it has to walk the CtrieSnapshot too.
+func (s *CtrieSnapshot) QuickStats() *Stats {
+	return s.Stats()
+}
+
+// Root returns a read-only NodeView of the root table of the CtrieSnapshot.
+// The NodeViews of its tables see through the iNodes; the child of a table is
+// the table, or the leaf, an iNode points to.
+func (s *CtrieSnapshot) Root() NodeView {
+	return s.rootView()
+}
+
+func (s *CtrieSnapshot) rootView() nodeView {
+	var root = s.ctrie.readRoot()
+	return nodeView{s.ctrie.resolve(root), 0, s.ctrie}
+}
+
+// Walk traverses the CtrieSnapshot calling fn with a NodeView of every table
+// and leaf (PreOrder) or of every leaf (LeafsOnly). The traversal stops, and
+// Walk returns false, if fn returns false.
+func (s *CtrieSnapshot) Walk(mode WalkMode, fn func(NodeView) bool) bool {
+	return s.rootView().walk(mode, func(v nodeView) bool { return fn(v) })
+}
@@ -0,0 +1,191 @@
+package hamt32
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Cursor is a position in hash order, the order Range() visits the KeyVal
+// pairs in. It is the HashVal of a leaf plus an offset into the leaf, which is
+// only non-zero for a collisionLeaf.
+//
+// The order only depends on the HashVals of the keys, not on the shape of the
+// Hamt, so a Cursor taken from one version of a HamtFunctional can be used
+// with any later version. KeyVal pairs added behind the Cursor are skipped,
+// and those added ahead of it are visited. The one exception is a
+// collisionLeaf that loses keys ahead of the offset, which shifts its
+// remaining keys back.
+//
+// The zero Cursor is the start of the Hamt. A Cursor can be serialized with
+// String() or MarshalText() and restored with ParseCursor() or
+// UnmarshalText().
+type Cursor struct {
+	hv     HashVal
+	offset uint
+}
+
+// String returns the serialized form of the Cursor.
+func (c Cursor) String() string {
+	return strconv.FormatUint(uint64(c.hv), 16) + "." +
+		strconv.FormatUint(uint64(c.offset), 16)
+}
+
+// ParseCursor restores a Cursor from the string returned by Cursor.String().
+func ParseCursor(s string) (Cursor, error) {
+	var parts = strings.Split(s, ".")
+	if len(parts) != 2 {
+		return Cursor{}, errors.Errorf("ParseCursor: malformed cursor %q", s)
+	}
+
+	var hv, err = strconv.ParseUint(parts[0], 16, int(hashSize))
+	if err != nil {
+		return Cursor{}, errors.Wrapf(err, "ParseCursor: bad HashVal in %q", s)
+	}
+
+	var offset uint64
+	offset, err = strconv.ParseUint(parts[1], 16, 0)
+	if err != nil {
+		return Cursor{}, errors.Wrapf(err, "ParseCursor: bad offset in %q", s)
+	}
+
+	return Cursor{HashVal(hv), uint(offset)}, nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (c Cursor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (c *Cursor) UnmarshalText(text []byte) error {
+	var nc, err = ParseCursor(string(text))
+	if err != nil {
+		return err
+	}
+	*c = nc
+	return nil
+}
+
+// compareOrder compares two HashVals in hash order; it returns -1, 0, or 1 if
+// a is before, the same as, or after b. Hash order compares the index at depth
+// 0 first, then the index at depth 1, and so on.
+func compareOrder(a, b HashVal) int {
+	for d := uint(0); d < DepthLimit; d++ {
+		var ai, bi = a.Index(d), b.Index(d)
+		if ai != bi {
+			if ai < bi {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// the bits above the top index, if any
+	var used = DepthLimit * NumIndexBits
+	var ar, br = a >> used, b >> used
+	switch {
+	case ar < br:
+		return -1
+	case ar > br:
+		return 1
+	}
+	return 0
+}
+
+// Seek returns a Cursor for the first leaf whose HashVal is at or after hv in
+// hash order and true, or the zero Cursor and false if there is no such leaf.
+func (h *hamtBase) Seek(hv HashVal) (Cursor, bool) {
+	var c Cursor
+	var found bool
+	h.rangeFrom(&h.root, 0, Cursor{hv, 0}, true,
+		func(l leafI, _ uint) bool {
+			c = Cursor{l.Hash(), 0}
+			found = true
+			return false
+		})
+	return c, found
+}
+
+// RangeFrom executes the given function for every KeyVal pair in the Hamt at
+// or after the Cursor, in the same order Range() visits them.
+//
+// If fn returns false the iteration stops and RangeFrom returns a Cursor for
+// the KeyVal pair after the last one fn was called with, and false. If the
+// iteration reaches the end of the Hamt, RangeFrom returns the zero Cursor and
+// true.
+//
+// Like Range(), it panics with a ConcurrentModification if a HamtTransient is
+// modified while fn is running; but it may be modified between calls.
+func (h *hamtBase) RangeFrom(
+	c Cursor,
+	fn func(KeyI, interface{}) bool,
+) (Cursor, bool) {
+	var next Cursor
+	var mods = h.mods
+	var done = h.rangeFrom(&h.root, 0, c, true,
+		func(l leafI, start uint) bool {
+			var kvs = l.keyVals()
+			for j := start; j < uint(len(kvs)); j++ {
+				var more = fn(kvs[j].Key, kvs[j].Val)
+				h.checkMods(mods, "RangeFrom")
+				if !more {
+					next = Cursor{l.Hash(), j + 1}
+					return false
+				}
+			}
+			return true
+		})
+	return next, done
+}
+
+// rangeFrom calls visit, in hash order, for every leaf in the subtree of t
+// that is at or after the Cursor, along with the offset to start at in the
+// leaf. onPath is true when the hashPath of t is a prefix of c.hv; only then
+// are there leafs before the Cursor to skip.
+//
+// rangeFrom returns false if visit did.
+func (h *hamtBase) rangeFrom(
+	t tableI,
+	depth uint,
+	c Cursor,
+	onPath bool,
+	visit func(leafI, uint) bool,
+) bool {
+	var cidx = c.hv.Index(depth)
+
+	var next = t.iter()
+	for n := next(); n != nil; n = next() {
+		var childOnPath bool
+		if onPath {
+			var idx = n.Hash().Index(depth)
+			if idx < cidx {
+				continue
+			}
+			childOnPath = idx == cidx
+		}
+
+		switch x := n.(type) {
+		case tableI:
+			if !h.rangeFrom(x, depth+1, c, childOnPath, visit) {
+				return false
+			}
+		case leafI:
+			var start uint
+			if childOnPath {
+				switch compareOrder(x.Hash(), c.hv) {
+				case -1:
+					continue
+				case 0:
+					start = c.offset
+				}
+			}
+			if !visit(x, start) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
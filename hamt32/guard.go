@@ -0,0 +1,102 @@
+package hamt32
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// GuardTransients makes NewTransient() construct guarded HamtTransients, as if
+// by NewGuardedTransient(). It is a debugging option for finding where a
+// HamtTransient escapes to a second goroutine; set it, before any
+// HamtTransients are made, in a test's init() or TestMain().
+var GuardTransients = false
+
+// OwnershipViolation is the value a guarded HamtTransient panics with when a
+// goroutine other than its owner modifies or iterates over it. Owner is the
+// stack trace of the owner when it took ownership, and Caller is the stack
+// trace of the offending goroutine.
+type OwnershipViolation struct {
+	Op            string
+	Owner, Caller []byte
+}
+
+func (e OwnershipViolation) Error() string {
+	return fmt.Sprintf("%s: HamtTransient used by a goroutine that does "+
+		"not own it\n\nowner %s\ncaller %s", e.Op, e.Owner, e.Caller)
+}
+
+// ownerGuard records which goroutine owns a guarded HamtTransient. The owner
+// is the goroutine that made the HamtTransient, or the first one to use it
+// after a Handoff(). Unguarded HamtTransients have a nil ownerGuard, so the
+// guard costs them one pointer comparison per operation.
+type ownerGuard struct {
+	lock  sync.Mutex
+	gid   uint64 // 0 when handed off
+	stack []byte
+}
+
+func newOwnerGuard() *ownerGuard {
+	var g = new(ownerGuard)
+	g.gid, g.stack = goroutineStack()
+	return g
+}
+
+// goroutineStack returns the id of the calling goroutine and its stack trace.
+// The id is parsed from the "goroutine N [running]:" header of the trace;
+// Go does not otherwise expose it, which is why this is only for debugging.
+func goroutineStack() (uint64, []byte) {
+	var buf = make([]byte, 4096)
+	for {
+		var n = runtime.Stack(buf, false)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var id = bytes.TrimPrefix(buf, []byte("goroutine "))
+	id = id[:bytes.IndexByte(id, ' ')]
+	var gid, err = strconv.ParseUint(string(id), 10, 64)
+	_ = assertOn && assertf(err == nil,
+		"goroutineStack(): bad stack header %q", buf)
+
+	return gid, buf
+}
+
+// checkOwner panics with an OwnershipViolation unless the calling goroutine
+// owns the HamtTransient, if it is guarded.
+func (h *hamtBase) checkOwner(op string) {
+	if h.guard != nil {
+		h.guard.check(op)
+	}
+}
+
+// check panics with an OwnershipViolation unless the calling goroutine owns
+// the HamtTransient. If it was handed off, the caller becomes the owner.
+func (g *ownerGuard) check(op string) {
+	var gid, stack = goroutineStack()
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	switch g.gid {
+	case gid:
+	case 0:
+		g.gid, g.stack = gid, stack
+	default:
+		panic(OwnershipViolation{op, g.stack, stack})
+	}
+}
+
+// handoff gives up the calling goroutine's ownership.
+func (g *ownerGuard) handoff() {
+	g.check("HamtTransient.Handoff")
+
+	g.lock.Lock()
+	g.gid, g.stack = 0, nil
+	g.lock.Unlock()
+}
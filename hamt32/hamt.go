@@ -1,6 +1,8 @@
 package hamt32
 
 import (
+	"context"
+	"math/rand"
 	"unsafe"
 )
 
@@ -95,7 +97,33 @@ type Hamt interface {
 	LongString(string) string
 	Range(func(KeyI, interface{}) bool)
 	Stats() *Stats
-	walk(visitFn) bool
+	QuickStats() *Stats
+	Root() NodeView
+	Walk(WalkMode, func(NodeView) bool) bool
+}
+
+// Ordered is the interface to the KeyVal pairs of a Hamt by their position in
+// hash order, the order Range() visits them in. Both the HamtFunctional and
+// HamtTransient data structures implement it.
+type Ordered interface {
+	Nth(uint) (KeyVal, bool)
+	Rank(KeyI) (uint, bool)
+	RandomEntry(rand.Source) (KeyVal, bool)
+	Sample(uint, rand.Source) []KeyVal
+	Seek(HashVal) (Cursor, bool)
+	RangeFrom(Cursor, func(KeyI, interface{}) bool) (Cursor, bool)
+}
+
+// Parallel is the interface to traversing a Hamt with several goroutines.
+// Both the HamtFunctional and HamtTransient data structures implement it.
+type Parallel interface {
+	ParallelRange(context.Context, int, func(KeyI, interface{}) bool) error
+	ParallelFold(
+		context.Context,
+		interface{},
+		func(interface{}, KeyI, interface{}) interface{},
+		func(interface{}, interface{}) interface{},
+	) (interface{}, error)
 }
 
 // KeyI interface specifies the two methods a datatype must implement to be used
@@ -168,6 +196,10 @@ type Stats struct {
 	// CollisionLeafs is the total count of collisionLeaf structs in the HAMT.
 	CollisionLeafs uint
 
+	// MaxCollisionLeafSize is the number of KeyVal pairs in the largest
+	// collisionLeaf in the HAMT.
+	MaxCollisionLeafSize uint
+
 	// KeyVals is the total number of KeyVal pairs int the HAMT.
 	KeyVals uint
 }
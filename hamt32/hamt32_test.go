@@ -1,11 +1,16 @@
 package hamt32_test
 
 import (
+	"context"
 	"log"
+	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/lleo/go-hamt/hamt32"
+	"github.com/lleo/go-hamt/hamt32/hamttest"
+	"github.com/pkg/errors"
 )
 
 func TestBuild64(t *testing.T) {
@@ -259,6 +264,279 @@ func runTestHamt64Del(
 	RunTime[name] = time.Since(StartTime[name])
 }
 
+func TestHamt64QuickStats(t *testing.T) {
+	runTestHamt64QuickStats(t, KVS64[:100000], Functional, TableOption)
+}
+
+func runTestHamt64QuickStats(
+	t *testing.T,
+	kvs []hamt32.KeyVal,
+	functional bool,
+	tblOpt int,
+) {
+	var name = "TestHamt64QuickStats"
+	if functional {
+		name += ":functional:" + hamt32.TableOptionName[tblOpt]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[tblOpt]
+	}
+
+	var checkStats = func(h hamt32.Hamt, when string) {
+		var stats = h.Stats()
+		var quick = h.QuickStats()
+		if *quick != *stats {
+			log.Printf("%s: %s: QuickStats()=%+v != Stats()=%+v",
+				name, when, quick, stats)
+			t.Fatalf("%s: %s: QuickStats()=%+v != Stats()=%+v",
+				name, when, quick, stats)
+		}
+	}
+
+	var h = hamt32.New(functional, tblOpt)
+	checkStats(h, "empty")
+
+	for i, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+		if i%10000 == 0 {
+			checkStats(h, "Put")
+		}
+	}
+	checkStats(h, "after Put")
+
+	// Replace values; this does not change the shape.
+	for _, kv := range kvs[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	checkStats(h, "after replace")
+
+	for i, kv := range kvs {
+		h, _, _ = h.Del(kv.Key)
+		if i%10000 == 0 {
+			checkStats(h, "Del")
+		}
+	}
+	checkStats(h, "after Del")
+}
+
+func TestHamt64CollisionHook(t *testing.T) {
+	var name = "TestHamt64CollisionHook"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var hv = hamt32.CalcHash([]byte("collide"))
+
+	var calls []uint
+	var hook = func(chv hamt32.HashVal, size uint) {
+		if chv != hv {
+			t.Fatalf("%s: hook called with hv=%s; expected %s", name, chv, hv)
+		}
+		calls = append(calls, size)
+	}
+
+	var h = hamt32.New(Functional, TableOption)
+	for _, kv := range KVS64[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	switch x := h.(type) {
+	case *hamt32.HamtFunctional:
+		h = x.SetCollisionHook(3, hook)
+	case *hamt32.HamtTransient:
+		h = x.SetCollisionHook(3, hook)
+	}
+
+	for i, n := range []string{"a", "b", "c", "d", "e"} {
+		h, _ = h.Put(hamttest.CollidingKey{Hv: hv, Name: n}, i)
+	}
+	// replacing a value does not grow the collisionLeaf
+	h, _ = h.Put(hamttest.CollidingKey{Hv: hv, Name: "a"}, 100)
+
+	// created at 2, then grew past 3 at 4 and 5
+	if len(calls) != 3 || calls[0] != 2 || calls[1] != 4 || calls[2] != 5 {
+		t.Fatalf("%s: hook calls=%v; expected [2 4 5]", name, calls)
+	}
+
+	var checkMax = func(expected uint) {
+		var stats = h.Stats()
+		var quick = h.QuickStats()
+		if stats.MaxCollisionLeafSize != expected {
+			t.Fatalf("%s: Stats().MaxCollisionLeafSize,%d != %d",
+				name, stats.MaxCollisionLeafSize, expected)
+		}
+		if *quick != *stats {
+			t.Fatalf("%s: QuickStats()=%+v != Stats()=%+v", name, quick, stats)
+		}
+	}
+	checkMax(5)
+
+	h, _, _ = h.Del(hamttest.CollidingKey{Hv: hv, Name: "c"})
+	checkMax(4)
+
+	for _, n := range []string{"a", "b", "d"} {
+		h, _, _ = h.Del(hamttest.CollidingKey{Hv: hv, Name: n})
+	}
+	checkMax(0)
+}
+
+func TestHamt64Walk(t *testing.T) {
+	var name = "TestHamt64Walk"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var h, err = buildHamt64(name, KVS64[:10000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var stats = h.Stats()
+	var counts [4]uint
+	var keyVals uint
+
+	h.Walk(hamt32.PreOrder, func(v hamt32.NodeView) bool {
+		counts[v.Kind()]++
+
+		for _, c := range v.Children() {
+			var c2, found = v.Child(c.Index())
+			if !found || c2.Kind() != c.Kind() {
+				t.Fatalf("%s: %s.Child(%d) != Children() entry %s",
+					name, v, c.Index(), c)
+			}
+			if c.Depth() != v.Depth()+1 {
+				t.Fatalf("%s: child %s of %s has wrong depth", name, c, v)
+			}
+			if c.HashPath()&v.HashPath() != v.HashPath() {
+				t.Fatalf("%s: child %s does not extend the hashPath of %s",
+					name, c, v)
+			}
+		}
+
+		if v.IsLeaf() {
+			keyVals += uint(len(v.KeyVals()))
+		}
+
+		return true
+	})
+
+	if counts[hamt32.FixedTableKind] != stats.FixedTables ||
+		counts[hamt32.SparseTableKind] != stats.SparseTables ||
+		counts[hamt32.FlatLeafKind] != stats.FlatLeafs ||
+		counts[hamt32.CollisionLeafKind] != stats.CollisionLeafs {
+		t.Fatalf("%s: Walk() counts=%v do not match Stats()=%+v",
+			name, counts, stats)
+	}
+	if keyVals != h.Nentries() {
+		t.Fatalf("%s: Walk() found %d KeyVals; expected %d",
+			name, keyVals, h.Nentries())
+	}
+
+	// LeafsOnly visits the leafs in the same order Range() visits KeyVals.
+	var rangeKeys []hamt32.KeyI
+	h.Range(func(k hamt32.KeyI, v interface{}) bool {
+		rangeKeys = append(rangeKeys, k)
+		return true
+	})
+
+	var i int
+	var completed = h.Walk(hamt32.LeafsOnly, func(v hamt32.NodeView) bool {
+		if v.IsTable() {
+			t.Fatalf("%s: LeafsOnly Walk() visited table %s", name, v)
+		}
+		for _, kv := range v.KeyVals() {
+			if !kv.Key.Equals(rangeKeys[i]) {
+				t.Fatalf("%s: Walk() key %s != Range() key %s",
+					name, kv.Key, rangeKeys[i])
+			}
+			i++
+		}
+		return i < 5000
+	})
+	if completed || i < 5000 {
+		t.Fatalf("%s: Walk() did not stop early; completed=%t i=%d",
+			name, completed, i)
+	}
+}
+
+// emptyView is a NodeView implemented outside of the hamt32 package, as a Hamt
+// of another package would implement it.
+type emptyView struct{}
+
+func (emptyView) Kind() hamt32.NodeKind              { return hamt32.NoneKind }
+func (emptyView) IsTable() bool                      { return false }
+func (emptyView) IsLeaf() bool                       { return false }
+func (emptyView) Depth() uint                        { return 0 }
+func (emptyView) Index() uint                        { return 0 }
+func (emptyView) HashPath() hamt32.HashVal           { return 0 }
+func (emptyView) Hash() hamt32.HashVal               { return 0 }
+func (emptyView) Nentries() uint                     { return 0 }
+func (emptyView) Count() uint                        { return 0 }
+func (emptyView) Child(uint) (hamt32.NodeView, bool) { return nil, false }
+func (emptyView) Children() []hamt32.NodeView        { return nil }
+func (emptyView) KeyVals() []hamt32.KeyVal           { return nil }
+func (emptyView) String() string                     { return "emptyView{}" }
+
+func TestHamt64NodeViewLeaf(t *testing.T) {
+	var name = "TestHamt64NodeViewLeaf"
+
+	var v hamt32.NodeView = emptyView{}
+	if hamt32.NodeKindName[v.Kind()] != "None" {
+		t.Fatalf("%s: NodeKindName[NoneKind] = %q", name,
+			hamt32.NodeKindName[v.Kind()])
+	}
+
+	var h, err = buildHamt64(name, KVS64[:1000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var leafs int
+	h.Walk(hamt32.LeafsOnly, func(v hamt32.NodeView) bool {
+		leafs++
+		if c, found := v.Child(0); found || c != nil {
+			t.Fatalf("%s: leaf %s.Child(0) = %v, %t", name, v, c, found)
+		}
+		if cs := v.Children(); cs != nil {
+			t.Fatalf("%s: leaf %s.Children() = %v", name, v, cs)
+		}
+		if v.Kind() == hamt32.NoneKind || v.Count() == 0 {
+			t.Fatalf("%s: leaf %s looks empty", name, v)
+		}
+		return true
+	})
+	if leafs == 0 {
+		t.Fatalf("%s: Walk() visited no leafs", name)
+	}
+
+	if c, found := h.Root().Child(hamt32.IndexLimit); found || c != nil {
+		t.Fatalf("%s: Root().Child(IndexLimit) = %v, %t", name, c, found)
+	}
+}
+
+func TestHamt64Model(t *testing.T) {
+	var name = "TestHamt64Model"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var keys = make([]hamt32.KeyI, 0, 5000+16)
+	for _, kv := range KVS64[:5000] {
+		keys = append(keys, kv.Key)
+	}
+	keys = append(keys,
+		hamttest.CollidingKeys(hamt32.CalcHash([]byte("collide")), 8)...)
+	keys = append(keys,
+		hamttest.DeepKeys(hamt32.CalcHash([]byte("deep")), 8)...)
+
+	var h = hamt32.New(Functional, TableOption)
+	hamttest.CheckModel(t, h, keys, 100000, rand.New(rand.NewSource(1)))
+}
+
 func BenchmarkHamt64Get(b *testing.B) {
 	runBenchmarkHamt64Get(b, KVS64, Functional, TableOption)
 }
@@ -305,6 +583,1581 @@ func runBenchmarkHamt64Get(
 	}
 }
 
+func TestHamt64Set(t *testing.T) {
+	var name = "TestHamt64Set"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var hv = hamt32.CalcHash([]byte("collide"))
+	var collide = hamttest.CollidingKeys(hv, 4)
+
+	// newSet builds a fresh Set each time, because the set operations modify
+	// a transient Set in place.
+	var newSet = func(kvs []hamt32.KeyVal, extra []hamt32.KeyI) *hamt32.Set {
+		var s = hamt32.NewSet(Functional, TableOption)
+		for _, kv := range kvs {
+			s, _ = s.Add(kv.Key)
+		}
+		for _, key := range extra {
+			s, _ = s.Add(key)
+		}
+		return s
+	}
+
+	var a = newSet(KVS64[:2000], collide[:3])
+	if a.Len() != 2003 {
+		t.Fatalf("%s: a.Len(),%d != 2003", name, a.Len())
+	}
+	if a.IsFunctional() != Functional {
+		t.Fatalf("%s: a.IsFunctional(),%t != %t", name, a.IsFunctional(),
+			Functional)
+	}
+	if _, added := a.Add(KVS64[0].Key); added {
+		t.Fatalf("%s: a.Add(%s) added a key already in the Set",
+			name, KVS64[0].Key)
+	}
+	if !a.Contains(collide[2]) || a.Contains(collide[3]) ||
+		a.Contains(KVS64[2000].Key) {
+		t.Fatalf("%s: a.Contains() is wrong", name)
+	}
+
+	var stats = a.Stats()
+	if stats.KeyVals != a.Len() || stats.CollisionLeafs != 1 ||
+		stats.MaxCollisionLeafSize != 3 {
+		t.Fatalf("%s: a.Stats()=%+v", name, stats)
+	}
+
+	var b = newSet(KVS64[1000:3000], collide[1:])
+
+	var u = newSet(KVS64[:2000], collide[:3]).Union(b)
+	if u.Len() != 3004 || !u.Equals(newSet(KVS64[:3000], collide)) {
+		t.Fatalf("%s: a.Union(b).Len(),%d != 3004", name, u.Len())
+	}
+
+	var i = newSet(KVS64[:2000], collide[:3]).Intersect(b)
+	if !i.Equals(newSet(KVS64[1000:2000], collide[1:3])) {
+		t.Fatalf("%s: a.Intersect(b).Len(),%d != 1002", name, i.Len())
+	}
+
+	var d = newSet(KVS64[:2000], collide[:3]).Difference(b)
+	if !d.Equals(newSet(KVS64[:1000], collide[:1])) {
+		t.Fatalf("%s: a.Difference(b).Len(),%d != 1001", name, d.Len())
+	}
+
+	if !i.IsSubset(a) || !i.IsSubset(b) || a.IsSubset(b) || !a.IsSubset(u) {
+		t.Fatalf("%s: IsSubset() is wrong", name)
+	}
+
+	if Functional && a.Len() != 2003 {
+		t.Fatalf("%s: set operations modified the functional Set a", name)
+	}
+
+	for _, key := range collide[:3] {
+		var removed bool
+		a, removed = a.Remove(key)
+		if !removed {
+			t.Fatalf("%s: a.Remove(%s) did not find the key", name, key)
+		}
+	}
+	if _, removed := a.Remove(collide[0]); removed {
+		t.Fatalf("%s: a.Remove(%s) removed a missing key", name, collide[0])
+	}
+	if !a.Equals(newSet(KVS64[:2000], nil)) {
+		t.Fatalf("%s: a != KVS64[:2000] after removing the colliding keys",
+			name)
+	}
+
+	// KeySet() views the trie of a Hamt
+	var h, err = buildHamt64(name, KVS64[:2000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var keys *hamt32.Set
+	switch x := h.(type) {
+	case *hamt32.HamtFunctional:
+		keys = x.KeySet()
+	case *hamt32.HamtTransient:
+		keys = x.KeySet()
+	}
+	if !keys.Equals(a) {
+		t.Fatalf("%s: h.KeySet() != a", name)
+	}
+}
+
+func TestHamt64MultiMap(t *testing.T) {
+	var name = "TestHamt64MultiMap"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	// 100 keys each with 20 values; the values are shared between keys
+	var keys = KVS64[:100]
+	var vals = KVS64[100:120]
+
+	var m = hamt32.NewMultiMap(Functional, TableOption)
+	for _, k := range keys {
+		for _, v := range vals {
+			var added bool
+			m, added = m.Add(k.Key, v.Key)
+			if !added {
+				t.Fatalf("%s: m.Add(%s, %s) did not add", name, k.Key, v.Key)
+			}
+		}
+	}
+	if m.Nkeys() != 100 || m.Count() != 2000 {
+		t.Fatalf("%s: m.Nkeys(),%d != 100 || m.Count(),%d != 2000",
+			name, m.Nkeys(), m.Count())
+	}
+	if _, added := m.Add(keys[0].Key, vals[0].Key); added {
+		t.Fatalf("%s: m.Add() added a duplicate value", name)
+	}
+
+	var orig = m
+
+	// remove every other value of keys[0]
+	for i, v := range vals {
+		if i%2 == 0 {
+			var removed bool
+			m, removed = m.Remove(keys[0].Key, v.Key)
+			if !removed {
+				t.Fatalf("%s: m.Remove(%s, %s) did not find the value",
+					name, keys[0].Key, v.Key)
+			}
+		}
+	}
+	if m.Values(keys[0].Key).Len() != 10 || m.Count() != 1990 {
+		t.Fatalf("%s: m.Values(%s).Len(),%d != 10 || m.Count(),%d != 1990",
+			name, keys[0].Key, m.Values(keys[0].Key).Len(), m.Count())
+	}
+	if m.Contains(keys[0].Key, vals[0].Key) ||
+		!m.Contains(keys[0].Key, vals[1].Key) {
+		t.Fatalf("%s: m.Contains() is wrong", name)
+	}
+
+	var removed *hamt32.Set
+	m, removed = m.RemoveAll(keys[1].Key)
+	if removed.Len() != 20 || m.Values(keys[1].Key) != nil ||
+		m.Nkeys() != 99 || m.Count() != 1970 {
+		t.Fatalf("%s: m.RemoveAll(%s) failed", name, keys[1].Key)
+	}
+
+	// removing the last value removes the key
+	for i := 1; i < len(vals); i += 2 {
+		m, _ = m.Remove(keys[0].Key, vals[i].Key)
+	}
+	if m.Values(keys[0].Key) != nil || m.Nkeys() != 98 {
+		t.Fatalf("%s: removing every value did not remove %s",
+			name, keys[0].Key)
+	}
+
+	var count uint
+	m.Range(func(k, v hamt32.KeyI) bool {
+		count++
+		return true
+	})
+	if count != m.Count() {
+		t.Fatalf("%s: m.Range() visited %d pairs; expected %d",
+			name, count, m.Count())
+	}
+
+	if Functional && (orig.Count() != 2000 ||
+		orig.Values(keys[0].Key).Len() != 20 ||
+		orig.Values(keys[1].Key).Len() != 20) {
+		t.Fatalf("%s: modifying m modified the original functional MultiMap",
+			name)
+	}
+}
+
+func TestHamt64Layered(t *testing.T) {
+	var name = "TestHamt64Layered"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var base, err = buildHamt64(name, KVS64[:1000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var bottom = hamt32.NewLayered(base)
+	var l = bottom.Push()
+
+	// shadow KVS64[:100], delete KVS64[100:200], add KVS64[1000:1100]
+	for _, kv := range KVS64[:100] {
+		var added bool
+		l, added = l.Put(kv.Key, "shadow")
+		if added {
+			t.Fatalf("%s: l.Put(%s) of a visible key returned added", name,
+				kv.Key)
+		}
+	}
+	for _, kv := range KVS64[100:200] {
+		var found bool
+		l, _, found = l.Del(kv.Key)
+		if !found {
+			t.Fatalf("%s: l.Del(%s) did not find the key", name, kv.Key)
+		}
+	}
+	for _, kv := range KVS64[1000:1100] {
+		l, _ = l.Put(kv.Key, kv.Val)
+	}
+	// a key only in the local layer is deleted, not tombstoned
+	l, _, _ = l.Del(KVS64[1099].Key)
+
+	var expected = make(map[hamt32.KeyI]interface{})
+	for _, kv := range KVS64[:1099] {
+		expected[kv.Key] = kv.Val
+	}
+	for _, kv := range KVS64[:100] {
+		expected[kv.Key] = "shadow"
+	}
+	for _, kv := range KVS64[100:200] {
+		delete(expected, kv.Key)
+	}
+
+	var check = func(what string, get func(hamt32.KeyI) (interface{}, bool),
+		rng func(func(hamt32.KeyI, interface{}) bool)) {
+		var n int
+		rng(func(k hamt32.KeyI, v interface{}) bool {
+			if expected[k] != v {
+				t.Fatalf("%s: %s Range() %s=%v; expected %v",
+					name, what, k, v, expected[k])
+			}
+			n++
+			return true
+		})
+		if n != len(expected) {
+			t.Fatalf("%s: %s Range() visited %d; expected %d",
+				name, what, n, len(expected))
+		}
+		for _, kv := range KVS64[:1100] {
+			var v, found = get(kv.Key)
+			var ev, efound = expected[kv.Key]
+			if found != efound || v != ev {
+				t.Fatalf("%s: %s Get(%s) => %v, %t; expected %v, %t",
+					name, what, kv.Key, v, found, ev, efound)
+			}
+		}
+	}
+
+	check("Layered", l.Get, l.Range)
+
+	var flat = l.Flatten()
+	check("Flatten()", flat.Get, flat.Range)
+	if flat.Nentries() != uint(len(expected)) {
+		t.Fatalf("%s: l.Flatten().Nentries(),%d != %d",
+			name, flat.Nentries(), len(expected))
+	}
+
+	if l.Depth() != 1 || l.Pop() != bottom || bottom.Pop() != nil {
+		t.Fatalf("%s: Push()/Pop() did not stack the layers", name)
+	}
+	if base.Nentries() != 1000 {
+		t.Fatalf("%s: modifying the top layer modified the base", name)
+	}
+}
+
+func TestHamt64OrderStats(t *testing.T) {
+	var name = "TestHamt64OrderStats"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var h, err = buildHamt64(name, KVS64[:10000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	for i := 0; i < 10000; i += 2 {
+		h, _, _ = h.Del(KVS64[i].Key)
+	}
+
+	if h.Root().Count() != h.Nentries() {
+		t.Fatalf("%s: h.Root().Count(),%d != h.Nentries(),%d",
+			name, h.Root().Count(), h.Nentries())
+	}
+
+	var pos uint
+	h.Range(func(k hamt32.KeyI, v interface{}) bool {
+		var kv, found = h.(hamt32.Ordered).Nth(pos)
+		if !found || !kv.Key.Equals(k) {
+			t.Fatalf("%s: h.Nth(%d)=%s; expected key %s", name, pos, kv, k)
+		}
+		var rank, _ = h.(hamt32.Ordered).Rank(k)
+		if rank != pos {
+			t.Fatalf("%s: h.Rank(%s),%d != %d", name, k, rank, pos)
+		}
+		pos++
+		return true
+	})
+	if _, found := h.(hamt32.Ordered).Nth(h.Nentries()); found {
+		t.Fatalf("%s: h.Nth(h.Nentries()) found an entry", name)
+	}
+	if _, found := h.(hamt32.Ordered).Rank(KVS64[0].Key); found {
+		t.Fatalf("%s: h.Rank(%s) found a deleted key", name, KVS64[0].Key)
+	}
+
+	var src = rand.NewSource(1)
+	if kv, found := h.(hamt32.Ordered).RandomEntry(src); !found {
+		t.Fatalf("%s: h.RandomEntry() found nothing", name)
+	} else if _, found = h.Get(kv.Key); !found {
+		t.Fatalf("%s: h.RandomEntry()=%s not in h", name, kv)
+	}
+
+	var sample = h.(hamt32.Ordered).Sample(100, src)
+	if len(sample) != 100 {
+		t.Fatalf("%s: len(h.Sample(100)),%d != 100", name, len(sample))
+	}
+	var last = -1
+	for _, kv := range sample {
+		var rank, found = h.(hamt32.Ordered).Rank(kv.Key)
+		if !found || int(rank) <= last {
+			t.Fatalf("%s: h.Sample() is not distinct keys of h in hash order",
+				name)
+		}
+		last = int(rank)
+	}
+	if len(h.(hamt32.Ordered).Sample(h.Nentries()+1, src)) != int(h.Nentries()) {
+		t.Fatalf("%s: h.Sample(h.Nentries()+1) did not return every entry",
+			name)
+	}
+}
+
+func TestHamt64Cursor(t *testing.T) {
+	var name = "TestHamt64Cursor"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var h, err = buildHamt64(name, KVS64[:5000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	for i, key := range hamttest.CollidingKeys(KVS64[0].Key.Hash(), 8) {
+		h, _ = h.Put(key, i)
+	}
+
+	var rangeKeys = func(h hamt32.Hamt) []hamt32.KeyI {
+		var keys []hamt32.KeyI
+		h.Range(func(k hamt32.KeyI, v interface{}) bool {
+			keys = append(keys, k)
+			return true
+		})
+		return keys
+	}
+
+	// page through 7 at a time, serializing the cursor between pages
+	var all = rangeKeys(h)
+	var paged []hamt32.KeyI
+	var token string
+	for {
+		var c hamt32.Cursor
+		if token != "" {
+			if c, err = hamt32.ParseCursor(token); err != nil {
+				t.Fatalf("%s: hamt32.ParseCursor(%q) => %s", name, token, err)
+			}
+		}
+
+		var n int
+		var page = func(k hamt32.KeyI, v interface{}) bool {
+			paged = append(paged, k)
+			n++
+			return n < 7
+		}
+		var next, done = h.(hamt32.Ordered).RangeFrom(c, page)
+		if done {
+			break
+		}
+		token = next.String()
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("%s: paged through %d keys; expected %d",
+			name, len(paged), len(all))
+	}
+	for i := range all {
+		if !paged[i].Equals(all[i]) {
+			t.Fatalf("%s: paged key %d is %s; Range() key is %s",
+				name, i, paged[i], all[i])
+		}
+	}
+
+	var c, found = h.(hamt32.Ordered).Seek(KVS64[0].Key.Hash())
+	if !found {
+		t.Fatalf("%s: h.Seek(%s) found nothing", name, KVS64[0].Key.Hash())
+	}
+	var first hamt32.KeyI
+	h.(hamt32.Ordered).RangeFrom(c, func(k hamt32.KeyI, v interface{}) bool {
+		first = k
+		return false
+	})
+	if !first.Equals(KVS64[0].Key) {
+		t.Fatalf("%s: h.Seek(%s) then RangeFrom() started at %s",
+			name, KVS64[0].Key.Hash(), first)
+	}
+
+	if !Functional {
+		return
+	}
+
+	// a cursor stays valid against a later version
+	var half hamt32.Cursor
+	var n int
+	half, _ = h.(hamt32.Ordered).RangeFrom(hamt32.Cursor{},
+		func(k hamt32.KeyI, v interface{}) bool {
+			n++
+			return n < len(all)/2
+		})
+
+	var h2 = h
+	for _, kv := range KVS64[5000:6000] {
+		h2, _ = h2.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS64[:1000] {
+		h2, _, _ = h2.Del(kv.Key)
+	}
+
+	var rest []hamt32.KeyI
+	h2.(hamt32.Ordered).RangeFrom(half, func(k hamt32.KeyI, v interface{}) bool {
+		rest = append(rest, k)
+		return true
+	})
+	var all2 = rangeKeys(h2)
+	var suffix = all2[len(all2)-len(rest):]
+	for i := range rest {
+		if !rest[i].Equals(suffix[i]) {
+			t.Fatalf("%s: resuming on a later version is not a suffix of its "+
+				"Range()", name)
+		}
+	}
+	for _, k := range all[:len(all)/2] {
+		for _, k2 := range rest {
+			if k.Equals(k2) {
+				t.Fatalf("%s: resuming on a later version revisited %s",
+					name, k)
+			}
+		}
+	}
+}
+
+func TestHamt64SplitJoin(t *testing.T) {
+	var name = "TestHamt64SplitJoin"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var h, err = buildHamt64(name, KVS64[:10000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	const n = 5
+	var shards = h.(interface {
+		Split(uint) []hamt32.Hamt
+	}).Split(n)
+	if len(shards) != n {
+		t.Fatalf("%s: len(h.Split(%d)),%d != %d", name, n, len(shards), n)
+	}
+
+	var total uint
+	for i, shard := range shards {
+		if err := hamttest.Validate(shard); err != nil {
+			t.Fatalf("%s: shard %d: %s", name, i, err)
+		}
+		total += shard.Nentries()
+	}
+	if total != 10000 {
+		t.Fatalf("%s: the shards hold %d entries; expected 10000", name, total)
+	}
+
+	if _, err := hamt32.Join(shards[0], shards[0]); err == nil {
+		t.Fatalf("%s: hamt32.Join() of overlapping shards succeeded", name)
+	}
+
+	// each goroutine adds the new keys that hash into its shard
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var shard = shards[i].ToTransient()
+			for _, kv := range KVS64[10000:12000] {
+				var idx = int(kv.Key.Hash().Index(0))
+				if idx >= i*hamt32.IndexLimit/n &&
+					idx < (i+1)*hamt32.IndexLimit/n {
+					shard.Put(kv.Key, kv.Val)
+				}
+			}
+			shards[i] = shard
+		}(i)
+	}
+	wg.Wait()
+
+	// the modified shards kept their Stats up to date
+	for i, shard := range shards {
+		if err := hamttest.Validate(shard); err != nil {
+			t.Fatalf("%s: modified shard %d: %s", name, i, err)
+		}
+	}
+
+	var joined hamt32.Hamt
+	joined, err = hamt32.Join(shards...)
+	if err != nil {
+		t.Fatalf("%s: hamt32.Join() => %s", name, err)
+	}
+	if err = hamttest.Validate(joined); err != nil {
+		t.Fatalf("%s: joined: %s", name, err)
+	}
+	if joined.Nentries() != 12000 {
+		t.Fatalf("%s: joined.Nentries(),%d != 12000", name, joined.Nentries())
+	}
+	for _, kv := range KVS64[:12000] {
+		if val, found := joined.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("%s: joined.Get(%s) => %v, %t", name, kv.Key, val, found)
+		}
+	}
+}
+
+func TestHamt64Parallel(t *testing.T) {
+	var name = "TestHamt64Parallel"
+	if Functional {
+		name += ":functional:" + hamt32.TableOptionName[TableOption]
+	} else {
+		name += ":transient:" + hamt32.TableOptionName[TableOption]
+	}
+
+	var h, err = buildHamt64(name, KVS64[:10000], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var mu sync.Mutex
+	var seen = make(map[hamt32.KeyI]bool)
+	err = h.(hamt32.Parallel).ParallelRange(context.Background(), 4,
+		func(k hamt32.KeyI, v interface{}) bool {
+			mu.Lock()
+			seen[k] = true
+			mu.Unlock()
+			return true
+		})
+	if err != nil {
+		t.Fatalf("%s: h.ParallelRange() => %s", name, err)
+	}
+	if len(seen) != 10000 {
+		t.Fatalf("%s: h.ParallelRange() saw %d keys; expected 10000",
+			name, len(seen))
+	}
+
+	var count, _ = h.(hamt32.Parallel).ParallelFold(context.Background(), 0,
+		func(acc interface{}, k hamt32.KeyI, v interface{}) interface{} {
+			return acc.(int) + 1
+		},
+		func(acc, sub interface{}) interface{} {
+			return acc.(int) + sub.(int)
+		})
+	if count != 10000 {
+		t.Fatalf("%s: h.ParallelFold() counted %v; expected 10000",
+			name, count)
+	}
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err = h.(hamt32.Parallel).ParallelFold(ctx, 0,
+		func(acc interface{}, k hamt32.KeyI, v interface{}) interface{} {
+			return acc
+		},
+		func(acc, sub interface{}) interface{} {
+			return acc
+		}); err != context.Canceled {
+		t.Fatalf("%s: cancelled h.ParallelFold() => %v", name, err)
+	}
+
+	// delete every even key of the first 10000 and add 2000 new keys
+	var batch []hamt32.BatchOp
+	for i := 0; i < 10000; i += 2 {
+		batch = append(batch, hamt32.BatchOp{Key: KVS64[i].Key, Del: true})
+	}
+	for _, kv := range KVS64[10000:12000] {
+		batch = append(batch, hamt32.BatchOp{Key: kv.Key, Val: kv.Val})
+	}
+
+	var th = h.ToTransient().(*hamt32.HamtTransient)
+	th.ParallelApply(batch)
+
+	if err = hamttest.Validate(th); err != nil {
+		t.Fatalf("%s: after th.ParallelApply(): %s", name, err)
+	}
+	if th.Nentries() != 7000 {
+		t.Fatalf("%s: th.Nentries(),%d != 7000", name, th.Nentries())
+	}
+	for i, kv := range KVS64[:12000] {
+		var val, found = th.Get(kv.Key)
+		if i < 10000 && i%2 == 0 {
+			if found {
+				t.Fatalf("%s: th.Get(%s) found deleted key", name, kv.Key)
+			}
+		} else if !found || val != kv.Val {
+			t.Fatalf("%s: th.Get(%s) => %v, %t", name, kv.Key, val, found)
+		}
+	}
+}
+
+func TestHamt64Builder(t *testing.T) {
+	var name = "TestHamt64Builder:" + hamt32.TableOptionName[TableOption]
+
+	var h, err = buildHamt64(name, KVS64[:10000], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	// the first 1000 keys are added twice; the second value must win
+	var kvs = make([]hamt32.KeyVal, 0, 11000)
+	for _, kv := range KVS64[:1000] {
+		kvs = append(kvs, hamt32.KeyVal{Key: kv.Key, Val: nil})
+	}
+	kvs = append(kvs, KVS64[:10000]...)
+
+	var bh = hamt32.FromSlice(kvs, TableOption)
+	if err = hamttest.Validate(bh); err != nil {
+		t.Fatalf("%s: hamt32.FromSlice(): %s", name, err)
+	}
+	if bh.Nentries() != 10000 {
+		t.Fatalf("%s: bh.Nentries(),%d != 10000", name, bh.Nentries())
+	}
+	for _, kv := range KVS64[:10000] {
+		if val, found := bh.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("%s: bh.Get(%s) => %v, %t", name, kv.Key, val, found)
+		}
+	}
+
+	// the shape, down to the table types, must match Put()ing the keys
+	if bh.LongString("") != h.LongString("") {
+		t.Fatalf("%s: hamt32.FromSlice() is not the same shape as Put()s",
+			name)
+	}
+
+	var i int
+	var ih = hamt32.FromIter(func() (hamt32.KeyI, interface{}, bool) {
+		if i == 10000 {
+			return nil, nil, false
+		}
+		i++
+		return KVS64[i-1].Key, KVS64[i-1].Val, true
+	}, TableOption)
+	if ih.LongString("") != h.LongString("") {
+		t.Fatalf("%s: hamt32.FromIter() is not the same shape as Put()s",
+			name)
+	}
+
+	if !hamt32.NewBuilder(TableOption).Build().IsEmpty() {
+		t.Fatalf("%s: an empty Builder built a non-empty Hamt", name)
+	}
+}
+
+func TestHamt64Ctrie(t *testing.T) {
+	var name = "TestHamt64Ctrie:" + hamt32.TableOptionName[TableOption]
+
+	var c = hamt32.NewCtrie(TableOption)
+	for _, kv := range KVS64[:5000] {
+		c.Put(kv.Key, kv.Val)
+	}
+	var snap = c.Snapshot()
+
+	// 8 goroutines each add 1000 new keys and delete 500 of the old ones
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 5000 + w; i < 13000; i += workers {
+				var kv = KVS64[i]
+				if !c.Put(kv.Key, kv.Val) {
+					t.Errorf("%s: c.Put(%s) did not add the key", name, kv.Key)
+				}
+			}
+			for i := w; i < 4000; i += workers {
+				if i%2 == 0 {
+					continue
+				}
+				var kv = KVS64[i]
+				if val, found := c.Del(kv.Key); !found || val != kv.Val {
+					t.Errorf("%s: c.Del(%s) => %v, %t",
+						name, kv.Key, val, found)
+				}
+			}
+		}(w)
+	}
+
+	// readers take snapshots while the writers work
+	for r := 0; r < 100; r++ {
+		var s = c.Snapshot()
+		var n = s.Nentries()
+		if n < 5000-2000 || n > 13000 {
+			t.Fatalf("%s: a Snapshot() held %d entries", name, n)
+		}
+		var m uint
+		s.Range(func(hamt32.KeyI, interface{}) bool {
+			m++
+			return true
+		})
+		if m != n {
+			t.Fatalf("%s: a Snapshot() held %d entries; Nentries()=%d",
+				name, m, n)
+		}
+	}
+	wg.Wait()
+
+	if n := snap.Nentries(); n != 5000 {
+		t.Fatalf("%s: the first Snapshot() changed; it holds %d entries",
+			name, n)
+	}
+	for _, kv := range KVS64[:5000] {
+		if val, found := snap.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("%s: snap.Get(%s) => %v, %t", name, kv.Key, val, found)
+		}
+	}
+
+	var h hamt32.Hamt = c.Snapshot()
+	if err := hamttest.Validate(h); err != nil {
+		t.Fatalf("%s: c.Snapshot(): %s", name, err)
+	}
+	if h.Nentries() != 11000 {
+		t.Fatalf("%s: h.Nentries(),%d != 11000", name, h.Nentries())
+	}
+
+	// a CtrieSnapshot behaves like a HamtFunctional
+	var kv = KVS64[13000]
+	var nh, added = h.Put(kv.Key, kv.Val)
+	if !added || nh.Nentries() != 11001 || h.Nentries() != 11000 {
+		t.Fatalf("%s: h.Put(%s) => %t; nh.Nentries()=%d, h.Nentries()=%d",
+			name, kv.Key, added, nh.Nentries(), h.Nentries())
+	}
+	if _, found := h.Get(kv.Key); found {
+		t.Fatalf("%s: h.Put() modified the original CtrieSnapshot", name)
+	}
+	nh, _, _ = nh.Del(KVS64[0].Key)
+	if err := hamttest.Validate(nh); err != nil {
+		t.Fatalf("%s: nh: %s", name, err)
+	}
+	if nh.Nentries() != 11000 {
+		t.Fatalf("%s: nh.Nentries(),%d != 11000", name, nh.Nentries())
+	}
+	if th := nh.ToTransient(); th.Nentries() != 11000 {
+		t.Fatalf("%s: nh.ToTransient().Nentries(),%d != 11000",
+			name, th.Nentries())
+	}
+	for i, kv := range KVS64[:13000] {
+		var val, found = c.Get(kv.Key)
+		if i < 4000 && i%2 == 1 {
+			if found {
+				t.Fatalf("%s: c.Get(%s) found a deleted key", name, kv.Key)
+			}
+		} else if !found || val != kv.Val {
+			t.Fatalf("%s: c.Get(%s) => %v, %t", name, kv.Key, val, found)
+		}
+	}
+
+	// deleting everything must contract the Ctrie back to an empty root
+	for _, kv := range KVS64[:13000] {
+		c.Del(kv.Key)
+	}
+	if n := c.Snapshot().Nentries(); n != 0 {
+		t.Fatalf("%s: the emptied Ctrie holds %d entries", name, n)
+	}
+}
+
+func TestHamt64ConcurrentTransient(t *testing.T) {
+	var name = "TestHamt64ConcurrentTransient:" +
+		hamt32.TableOptionName[TableOption]
+
+	var c = hamt32.NewConcurrentTransient(TableOption)
+
+	// 8 goroutines each add 1500 keys, then delete a third of them
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 12000; i += workers {
+				if !c.Put(KVS64[i].Key, KVS64[i].Val) {
+					t.Errorf("%s: c.Put(%s) did not add the key",
+						name, KVS64[i].Key)
+				}
+			}
+			for i := w; i < 12000; i += workers {
+				if i%3 != 0 {
+					continue
+				}
+				var val, found = c.Del(KVS64[i].Key)
+				if !found || val != KVS64[i].Val {
+					t.Errorf("%s: c.Del(%s) => %v, %t",
+						name, KVS64[i].Key, val, found)
+				}
+			}
+		}(w)
+	}
+
+	// readers take snapshots while the writers work
+	for r := 0; r < 20; r++ {
+		if err := hamttest.Validate(c.Snapshot()); err != nil {
+			t.Fatalf("%s: c.Snapshot(): %s", name, err)
+		}
+	}
+	wg.Wait()
+
+	if c.Nentries() != 8000 {
+		t.Fatalf("%s: c.Nentries(),%d != 8000", name, c.Nentries())
+	}
+
+	var h = c.Snapshot()
+	if err := hamttest.Validate(h); err != nil {
+		t.Fatalf("%s: c.Snapshot(): %s", name, err)
+	}
+	if h.Nentries() != 8000 {
+		t.Fatalf("%s: h.Nentries(),%d != 8000", name, h.Nentries())
+	}
+
+	var n int
+	c.Range(func(k hamt32.KeyI, v interface{}) bool {
+		if val, found := h.Get(k); !found || val != v {
+			t.Fatalf("%s: h.Get(%s) => %v, %t", name, k, val, found)
+		}
+		n++
+		return true
+	})
+	if n != 8000 {
+		t.Fatalf("%s: c.Range() visited %d entries; expected 8000", name, n)
+	}
+
+	// the Snapshot is a copy, so modifying c must not change it
+	c.Put(KVS64[0].Key, KVS64[0].Val)
+	if _, found := h.Get(KVS64[0].Key); found {
+		t.Fatalf("%s: modifying c changed its Snapshot()", name)
+	}
+
+	// fn may use c; emptying c from inside Range() must not deadlock
+	c.Range(func(k hamt32.KeyI, v interface{}) bool {
+		if _, found := c.Get(k); found {
+			c.Del(k)
+		}
+		return true
+	})
+	if n := c.Nentries(); n != 0 {
+		t.Fatalf("%s: c.Range() calling c.Del() left %d entries", name, n)
+	}
+}
+
+func TestHamt64Diff(t *testing.T) {
+	var name = "TestHamt64Diff:" + hamt32.TableOptionName[TableOption]
+
+	var h, err = buildHamt64(name, KVS64[:10000], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var nh = h
+	for _, kv := range KVS64[:100] {
+		nh, _, _ = nh.Del(kv.Key)
+	}
+	for _, kv := range KVS64[100:200] {
+		nh, _ = nh.Put(kv.Key, -1)
+	}
+	for _, kv := range KVS64[200:300] {
+		nh, _ = nh.Put(kv.Key, kv.Val) // same value; not a change
+	}
+	for _, kv := range KVS64[10000:10100] {
+		nh, _ = nh.Put(kv.Key, kv.Val)
+	}
+
+	var counts [3]int
+	hamt32.Diff(h, nh, func(c hamt32.Change) bool {
+		counts[c.Kind]++
+		var _, inOld = h.Get(c.Key)
+		var _, inNew = nh.Get(c.Key)
+		if (c.Kind == hamt32.Added && (inOld || !inNew)) ||
+			(c.Kind == hamt32.Removed && (!inOld || inNew)) ||
+			(c.Kind == hamt32.Modified && (!inOld || !inNew || c.New != -1)) {
+			t.Fatalf("%s: bad Change %s %s", name,
+				hamt32.ChangeKindName[c.Kind], c.Key)
+		}
+		return true
+	})
+	if counts != [3]int{100, 100, 100} {
+		t.Fatalf("%s: hamt32.Diff() counted %v; expected [100 100 100]",
+			name, counts)
+	}
+
+	// an unrelated Hamt with the same entries has no differences
+	var bh = hamt32.FromSlice(KVS64[:10000], TableOption)
+	if !hamt32.Diff(h, bh, func(c hamt32.Change) bool { return false }) {
+		t.Fatalf("%s: equal Hamts have a difference", name)
+	}
+}
+
+func TestHamt64STM(t *testing.T) {
+	var name = "TestHamt64STM:" + hamt32.TableOptionName[TableOption]
+
+	// 1000 accounts, split across two Refs, with a balance of 100 each
+	var refs [2]*hamt32.Ref
+	for i := range refs {
+		var h = hamt32.New(true, TableOption)
+		for _, kv := range KVS64[i*500 : (i+1)*500] {
+			h, _ = h.Put(kv.Key, 100)
+		}
+		refs[i] = hamt32.NewRef(h)
+	}
+
+	// 8 goroutines make 500 random transfers each, between the Refs
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var rnd = rand.New(rand.NewSource(int64(w)))
+			for n := 0; n < 500; n++ {
+				var from, to = rnd.Intn(500), 500 + rnd.Intn(500)
+				if n%2 == 0 {
+					from, to = to, from
+				}
+				var err = hamt32.Atomically(func(tx *hamt32.Tx) error {
+					var fromRef, toRef = refs[from/500], refs[to/500]
+					var fromKey, toKey = KVS64[from].Key, KVS64[to].Key
+					var fb, _ = tx.Get(fromRef, fromKey)
+					var tb, _ = tx.Get(toRef, toKey)
+					tx.Put(fromRef, fromKey, fb.(int)-1)
+					tx.Put(toRef, toKey, tb.(int)+1)
+					return nil
+				})
+				if err != nil {
+					t.Errorf("%s: hamt32.Atomically() => %s", name, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var total int
+	var err = hamt32.Atomically(func(tx *hamt32.Tx) error {
+		total = 0
+		for _, ref := range refs {
+			tx.Hamt(ref).Range(func(k hamt32.KeyI, v interface{}) bool {
+				total += v.(int)
+				return true
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%s: hamt32.Atomically() => %s", name, err)
+	}
+	if total != 100000 {
+		t.Fatalf("%s: the accounts total %d; expected 100000", name, total)
+	}
+
+	// a Tx that returns an error publishes nothing
+	var before = refs[0].Get()
+	err = hamt32.Atomically(func(tx *hamt32.Tx) error {
+		tx.Del(refs[0], KVS64[0].Key)
+		return errors.New("abort")
+	})
+	if err == nil || refs[0].Get() != before {
+		t.Fatalf("%s: an aborted Tx => %v; modified the Ref", name, err)
+	}
+}
+
+func TestHamt64Observable(t *testing.T) {
+	var name = "TestHamt64Observable:" + hamt32.TableOptionName[TableOption]
+
+	var h, err = buildHamt64(name, KVS64[:1000], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var o = hamt32.NewObservable(h, 1000)
+	var block = o.Subscribe(10, hamt32.Block)
+	var drop = o.Subscribe(5, hamt32.Drop)
+	var coalesce = o.Subscribe(1, hamt32.Coalesce)
+
+	var blocked []hamt32.Event
+	var blockDone = make(chan bool)
+	go func() {
+		for ev := range block.C {
+			blocked = append(blocked, ev)
+		}
+		close(blockDone)
+	}()
+
+	// 200 Puts of new keys, 100 Puts of old keys, 100 Dels, and an Update
+	// that deletes 100 more keys
+	for _, kv := range KVS64[1000:1200] {
+		o.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS64[:100] {
+		o.Put(kv.Key, -1)
+	}
+	for _, kv := range KVS64[100:200] {
+		o.Del(kv.Key)
+	}
+	var version = o.Update(func(h hamt32.Hamt) hamt32.Hamt {
+		for _, kv := range KVS64[200:300] {
+			h, _, _ = h.Del(kv.Key)
+		}
+		return h
+	})
+	if version != 401 {
+		t.Fatalf("%s: the last version,%d != 401", name, version)
+	}
+
+	// a Put() of the value a key already has publishes nothing
+	if version, _ = o.Put(KVS64[0].Key, -1); version != 401 {
+		t.Fatalf("%s: a Put() of an equal value made version %d",
+			name, version)
+	}
+	var final, _ = o.Get()
+
+	// the Coalesce subscriber's replica must converge on the final version
+	var replica = h
+	var timeout = time.After(10 * time.Second)
+	for hamt32.Diff(replica, final, func(hamt32.Change) bool {
+		return false
+	}) == false {
+		select {
+		case ev := <-coalesce.C:
+			if ev.Kind == hamt32.Removed {
+				replica, _, _ = replica.Del(ev.Key)
+			} else {
+				replica, _ = replica.Put(ev.Key, ev.New)
+			}
+		case <-timeout:
+			t.Fatalf("%s: the Coalesce replica never caught up", name)
+		}
+	}
+
+	block.Unsubscribe()
+	<-blockDone
+	if len(blocked) != 500 {
+		t.Fatalf("%s: the Block subscriber got %d Events; expected 500",
+			name, len(blocked))
+	}
+	if blocked[499].Version != 401 || blocked[0].Kind != hamt32.Added {
+		t.Fatalf("%s: the Block subscriber got the wrong Events", name)
+	}
+
+	if len(drop.C) != 5 || drop.Dropped() != 495 {
+		t.Fatalf("%s: the Drop subscriber has %d Events and dropped %d",
+			name, len(drop.C), drop.Dropped())
+	}
+	drop.Unsubscribe()
+	coalesce.Unsubscribe()
+
+	var counts [3]int
+	version, err = o.CatchUp(0, func(ev hamt32.Event) bool {
+		counts[ev.Kind]++
+		return true
+	})
+	if err != nil || version != 401 || counts != [3]int{200, 200, 100} {
+		t.Fatalf("%s: o.CatchUp(0) => %d, %v; counted %v",
+			name, version, err, counts)
+	}
+
+	// Dropped() does not wait for a writer blocked by a Block subscriber
+	var stalled = hamt32.NewObservable(h, 1)
+	var stall = stalled.Subscribe(0, hamt32.Block)
+	var drops = stalled.Subscribe(0, hamt32.Drop)
+	go stalled.Put(KVS64[1000].Key, KVS64[1000].Val)
+	time.Sleep(10 * time.Millisecond)
+	var dropped = make(chan uint64)
+	go func() { dropped <- drops.Dropped() }()
+	select {
+	case <-dropped:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s: Dropped() waited for a blocked writer", name)
+	}
+	stall.Unsubscribe()
+	drops.Unsubscribe()
+
+	var small = hamt32.NewObservable(h, 2)
+	for _, kv := range KVS64[1000:1005] {
+		small.Put(kv.Key, kv.Val)
+	}
+	if _, err = small.CatchUp(1, func(hamt32.Event) bool {
+		return true
+	}); errors.Cause(err) != hamt32.ErrVersionGone {
+		t.Fatalf("%s: small.CatchUp(1) => %v", name, err)
+	}
+}
+
+func TestHamt64FailFast(t *testing.T) {
+	var name = "TestHamt64FailFast:" + hamt32.TableOptionName[TableOption]
+
+	var h, err = buildHamt64(name, KVS64[:10000], false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var panicked = func(fn func()) (r interface{}) {
+		defer func() { r = recover() }()
+		fn()
+		return nil
+	}
+
+	var r = panicked(func() {
+		var i int
+		h.Range(func(k hamt32.KeyI, v interface{}) bool {
+			i++
+			if i == 10 {
+				h.Put(KVS64[10000].Key, KVS64[10000].Val)
+			}
+			return true
+		})
+	})
+	if _, isCM := r.(hamt32.ConcurrentModification); !isCM {
+		t.Fatalf("%s: Put() during Range() => %v", name, r)
+	}
+	h.Del(KVS64[10000].Key)
+
+	r = panicked(func() {
+		var it = h.(*hamt32.HamtTransient).Iter()
+		it.Next()
+		h.Del(it.Key())
+		it.Next()
+	})
+	if _, isCM := r.(hamt32.ConcurrentModification); !isCM {
+		t.Fatalf("%s: Del() during an Iterator => %v", name, r)
+	}
+
+	// delete every other key through the Iterator
+	var seen, kept int
+	for it := h.(*hamt32.HamtTransient).Iter(); it.Next(); seen++ {
+		if seen%2 == 0 {
+			it.Delete()
+		} else {
+			kept++
+		}
+	}
+	if seen != 9999 || h.Nentries() != uint(kept) {
+		t.Fatalf("%s: saw %d keys and kept %d; h.Nentries() = %d",
+			name, seen, kept, h.Nentries())
+	}
+	if err = hamttest.Validate(h); err != nil {
+		t.Fatalf("%s: after Iterator.Delete(): %s", name, err)
+	}
+
+	var n int
+	for it := h.(*hamt32.HamtTransient).Iter(); it.Next(); n++ {
+		if val, found := h.Get(it.Key()); !found || val != it.Val() {
+			t.Fatalf("%s: h.Get(%s) => %v, %t", name, it.Key(), val, found)
+		}
+	}
+	if n != kept {
+		t.Fatalf("%s: Iterated over %d keys; expected %d", name, n, kept)
+	}
+
+	if r = panicked(func() {
+		var it = h.ToFunctional().(*hamt32.HamtFunctional).Iter()
+		it.Next()
+		it.Delete()
+	}); r == nil {
+		t.Fatalf("%s: Iterator.Delete() on a HamtFunctional did not panic",
+			name)
+	}
+}
+
+func TestHamt64Guard(t *testing.T) {
+	var name = "TestHamt64Guard:" + hamt32.TableOptionName[TableOption]
+
+	var h = hamt32.NewGuardedTransient(TableOption)
+	for _, kv := range KVS64[:1000] {
+		h.Put(kv.Key, kv.Val)
+	}
+
+	var other = func(fn func()) (r interface{}) {
+		var done = make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() { r = recover() }()
+			fn()
+		}()
+		<-done
+		return r
+	}
+
+	var r = other(func() { h.Del(KVS64[0].Key) })
+	var ov, isOV = r.(hamt32.OwnershipViolation)
+	if !isOV {
+		t.Fatalf("%s: Del() from another goroutine => %v", name, r)
+	}
+	if len(ov.Owner) == 0 || len(ov.Caller) == 0 ||
+		string(ov.Owner) == string(ov.Caller) {
+		t.Fatalf("%s: bad OwnershipViolation stacks: %s", name, ov)
+	}
+	if _, found := h.Get(KVS64[0].Key); !found {
+		t.Fatalf("%s: the violating Del() deleted the key", name)
+	}
+
+	h.Handoff()
+	if r = other(func() {
+		h.Del(KVS64[0].Key)
+		h.Range(func(hamt32.KeyI, interface{}) bool { return true })
+	}); r != nil {
+		t.Fatalf("%s: Del() after Handoff() => %v", name, r)
+	}
+	if r = other(func() { h.Handoff() }); r == nil {
+		t.Fatalf("%s: Handoff() by a non-owner did not panic", name)
+	}
+
+	// the goroutine that took over has exited, but it still owns h
+	var panicked bool
+	func() {
+		defer func() { panicked = recover() != nil }()
+		h.Put(KVS64[0].Key, KVS64[0].Val)
+	}()
+	if !panicked {
+		t.Fatalf("%s: Put() by the previous owner did not panic", name)
+	}
+
+	// every modifying or iterating entry point is guarded
+	var ops = map[string]func(){
+		"ParallelApply": func() {
+			h.ParallelApply([]hamt32.BatchOp{{Key: KVS64[0].Key}})
+		},
+		"Iter":      func() { h.Iter() },
+		"Seek":      func() { h.Seek(0) },
+		"Savepoint": func() { h.Savepoint() },
+		"RangeFrom": func() {
+			h.RangeFrom(hamt32.Cursor{},
+				func(hamt32.KeyI, interface{}) bool { return true })
+		},
+	}
+	for op, fn := range ops {
+		var r interface{}
+		func() {
+			defer func() { r = recover() }()
+			fn()
+		}()
+		if _, isOV := r.(hamt32.OwnershipViolation); !isOV {
+			t.Fatalf("%s: %s() by the previous owner => %v", name, op, r)
+		}
+	}
+
+	// ToFunctional() drops the guard, so it does not come back with
+	// ToTransient(), nor in the copies made by Put()
+	var fh = hamt32.NewGuardedTransient(TableOption).ToFunctional()
+	fh, _ = fh.Put(KVS64[0].Key, KVS64[0].Val)
+	if r = other(func() {
+		fh.ToTransient().Put(KVS64[1].Key, KVS64[1].Val)
+	}); r != nil {
+		t.Fatalf("%s: Put() after ToFunctional().ToTransient() => %v",
+			name, r)
+	}
+
+	// unguarded HamtTransients do not care
+	var u = hamt32.NewTransient(TableOption)
+	u.Handoff()
+	if r = other(func() { u.Put(KVS64[0].Key, KVS64[0].Val) }); r != nil {
+		t.Fatalf("%s: Put() on an unguarded HamtTransient => %v", name, r)
+	}
+}
+
+func TestHamt64Savepoint(t *testing.T) {
+	var name = "TestHamt64Savepoint:" + hamt32.TableOptionName[TableOption]
+
+	var h, err = buildHamt64(name, KVS64[:5000], false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var orig = h.DeepCopy()
+
+	var same = func(a, b hamt32.Hamt) bool {
+		return hamt32.Diff(a, b, func(hamt32.Change) bool { return false })
+	}
+
+	var th = h.(*hamt32.HamtTransient)
+	var outer = th.Savepoint()
+	for _, kv := range KVS64[5000:7000] {
+		th.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS64[:1000] {
+		th.Del(kv.Key)
+	}
+	var afterOuter = th.DeepCopy()
+
+	var inner = th.Savepoint()
+	for _, kv := range KVS64[1000:2000] {
+		th.Put(kv.Key, "replaced")
+	}
+	for _, kv := range KVS64[:1000] {
+		th.Put(kv.Key, kv.Val)
+	}
+	th.Rollback(inner)
+	if !same(th, afterOuter) {
+		t.Fatalf("%s: Rollback(inner) did not undo the inner changes", name)
+	}
+
+	var released = th.Savepoint()
+	for _, kv := range KVS64[2000:3000] {
+		th.Del(kv.Key)
+	}
+	th.Release(released)
+
+	th.Rollback(outer)
+	if !same(th, orig) || th.Nentries() != 5000 {
+		t.Fatalf("%s: Rollback(outer) did not restore the original", name)
+	}
+	if err = hamttest.Validate(th); err != nil {
+		t.Fatalf("%s: after Rollback(outer): %s", name, err)
+	}
+
+	var panics = func(fn func()) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		fn()
+		return false
+	}
+	if !panics(func() { th.Rollback(inner) }) {
+		t.Fatalf("%s: Rollback() of an inactive Savepoint did not panic", name)
+	}
+
+	// a Savepoint stays inactive after a new one is made in its place
+	var stale = th.Savepoint()
+	th.Release(stale)
+	var sp = th.Savepoint()
+	th.Put(KVS64[5000].Key, KVS64[5000].Val)
+	if !panics(func() { th.Rollback(stale) }) {
+		t.Fatalf("%s: Rollback() of a released Savepoint did not panic", name)
+	}
+	if _, found := th.Get(KVS64[5000].Key); !found {
+		t.Fatalf("%s: Rollback() of a released Savepoint undid a Put()", name)
+	}
+
+	// a Savepoint of another HamtTransient is not active
+	var other = hamt32.NewTransient(TableOption)
+	var foreign = other.Savepoint()
+	if !panics(func() { th.Release(foreign) }) {
+		t.Fatalf("%s: Release() of another HamtTransient's Savepoint did "+
+			"not panic", name)
+	}
+	th.Rollback(sp)
+	if th.Nentries() != 5000 {
+		t.Fatalf("%s: th.Nentries(),%d != 5000", name, th.Nentries())
+	}
+}
+
+func TestHamt64VersionStore(t *testing.T) {
+	var name = "TestHamt64VersionStore:" + hamt32.TableOptionName[TableOption]
+
+	var h, err = buildHamt64(name, KVS64[:1000], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var s = hamt32.NewVersionStore(hamt32.RetentionPolicy{})
+	var key = KVS64[0].Key
+	var times []time.Time
+	for i := 0; i < 10; i++ {
+		h, _ = h.Put(key, i)
+		h, _ = h.Put(KVS64[1000+i].Key, KVS64[1000+i].Val)
+		var tag []string
+		if i == 3 {
+			tag = append(tag, "audit")
+		}
+		var v = s.Commit(h, tag...)
+		if v.ID != uint64(i+1) {
+			t.Fatalf("%s: Commit() => ID %d; expected %d", name, v.ID, i+1)
+		}
+		times = append(times, v.Time)
+		time.Sleep(time.Millisecond)
+	}
+
+	for i, ts := range times {
+		if val, found := s.Get(key, ts); !found || val != i {
+			t.Fatalf("%s: s.Get(key, times[%d]) => %v, %t", name, i, val, found)
+		}
+	}
+	if _, found := s.AsOf(times[0].Add(-time.Second)); found {
+		t.Fatalf("%s: s.AsOf() before the first Version found one", name)
+	}
+	if v, found := s.AsOfID(5); !found || v.Time != times[4] {
+		t.Fatalf("%s: s.AsOfID(5) => %v, %t", name, v, found)
+	}
+
+	if v, _ := s.Undo(); v.ID != 9 {
+		t.Fatalf("%s: s.Undo() => ID %d; expected 9", name, v.ID)
+	}
+	s.Undo()
+	if v, _ := s.Redo(); v.ID != 9 || s.Current().ID != 9 {
+		t.Fatalf("%s: s.Redo() => ID %d; expected 9", name, v.ID)
+	}
+	if _, ok := s.Redo(); !ok {
+		t.Fatalf("%s: s.Redo() to the newest Version failed", name)
+	}
+	if _, ok := s.Redo(); ok {
+		t.Fatalf("%s: s.Redo() past the newest Version succeeded", name)
+	}
+
+	// each Version only adds the path to the two keys it changed
+	var costs, total = s.MemoryReport()
+	var sum uintptr
+	for i, c := range costs {
+		sum += c.Added
+		if i > 0 && (c.Added*4 > c.Bytes || c.Unique > c.Added) {
+			t.Fatalf("%s: Version %d costs %+v", name, c.ID, c)
+		}
+	}
+	if sum != total || total > costs[0].Bytes*2 {
+		t.Fatalf("%s: total,%d; sum of Added,%d; Bytes of the first,%d",
+			name, total, sum, costs[0].Bytes)
+	}
+
+	s.SetRetention(hamt32.RetentionPolicy{MaxVersions: 3, KeepTagged: true})
+	var ids []uint64
+	for _, v := range s.Versions() {
+		ids = append(ids, v.ID)
+	}
+	if len(ids) != 4 || ids[0] != 4 || ids[1] != 8 {
+		t.Fatalf("%s: retained Versions %v; expected [4 8 9 10]", name, ids)
+	}
+	if v, found := s.Tagged("audit"); !found || v.ID != 4 {
+		t.Fatalf("%s: s.Tagged(\"audit\") => %v, %t", name, v, found)
+	}
+	// Version 4 was current until Version 5, which is gone
+	if _, found := s.Get(key, times[3]); found {
+		t.Fatalf("%s: s.Get() found a value of a dropped Version", name)
+	}
+	if val, found := s.Get(key, times[7]); !found || val != 7 {
+		t.Fatalf("%s: s.Get(key, times[7]) => %v, %t", name, val, found)
+	}
+
+	s.SetRetention(hamt32.RetentionPolicy{MaxAge: time.Nanosecond})
+	if vs := s.Versions(); len(vs) != 1 || vs[0].ID != 10 {
+		t.Fatalf("%s: MaxAge did not keep only the newest Version", name)
+	}
+
+	// a Hamt that is not a HamtFunctional is measured through its NodeViews
+	var c = hamt32.NewCtrie(TableOption)
+	for _, kv := range KVS64[:1000] {
+		c.Put(kv.Key, kv.Val)
+	}
+	var cs = hamt32.NewVersionStore(hamt32.RetentionPolicy{})
+	cs.Commit(h)
+	cs.Commit(c.Snapshot())
+	costs, total = cs.MemoryReport()
+	if len(costs) != 2 || costs[1].Bytes == 0 ||
+		total != costs[0].Added+costs[1].Added {
+		t.Fatalf("%s: MemoryReport() with a CtrieSnapshot => %+v, %d",
+			name, costs, total)
+	}
+}
+
+func TestHamt64Merge3(t *testing.T) {
+	var name = "TestHamt64Merge3:" + hamt32.TableOptionName[TableOption]
+
+	var base, err = buildHamt64(name, KVS64[:5000], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var ours, theirs = base, base
+	for i := 0; i < 400; i++ {
+		var key = KVS64[i].Key
+		switch {
+		case i < 50:
+			ours, _ = ours.Put(key, "ours")
+		case i < 100:
+			ours, _ = ours.Put(key, "ours")
+			theirs, _ = theirs.Put(key, "theirs")
+		case i < 150:
+			ours, _, _ = ours.Del(key)
+			theirs, _ = theirs.Put(key, "theirs")
+		case i < 200:
+			ours, _, _ = ours.Del(key)
+			theirs, _, _ = theirs.Del(key)
+		case i < 250:
+			theirs, _, _ = theirs.Del(key)
+		case i < 300:
+			ours, _ = ours.Put(key, "same")
+			theirs, _ = theirs.Put(key, "same")
+		default:
+			theirs, _ = theirs.Put(key, "theirs")
+		}
+	}
+	for _, kv := range KVS64[5000:5100] {
+		ours, _ = ours.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS64[5100:5200] {
+		theirs, _ = theirs.Put(kv.Key, kv.Val)
+	}
+
+	var expected = func(i int) (interface{}, bool) {
+		switch {
+		case i < 50:
+			return "ours", true
+		case i < 150:
+			return "theirs", true
+		case i < 250:
+			return nil, false
+		case i < 300:
+			return "same", true
+		case i < 400:
+			return "theirs", true
+		}
+		return KVS64[i].Val, i < 5200
+	}
+
+	var merged, conflicts = hamt32.Merge3(base, ours, theirs, hamt32.KeepTheirs)
+	if err = hamttest.Validate(merged); err != nil {
+		t.Fatalf("%s: hamt32.Merge3(): %s", name, err)
+	}
+	if len(conflicts) != 100 {
+		t.Fatalf("%s: found %d conflicts; expected 100", name, len(conflicts))
+	}
+	for _, c := range conflicts {
+		if !c.InBase || !c.InTheirs || c.Theirs != "theirs" {
+			t.Fatalf("%s: bad Conflict %+v", name, c)
+		}
+	}
+	if merged.Nentries() != 5200-100 {
+		t.Fatalf("%s: merged.Nentries(),%d != 5100", name, merged.Nentries())
+	}
+	for i := range KVS64[:5200] {
+		var val, found = merged.Get(KVS64[i].Key)
+		var eval, efound = expected(i)
+		if found != efound || val != eval {
+			t.Fatalf("%s: merged.Get(KVS64[%d].Key) => %v, %t; expected %v, %t",
+				name, i, val, found, eval, efound)
+		}
+	}
+
+	// merging into an unchanged ours grafts theirs' subtrees
+	var same = func(a, b hamt32.Hamt) bool {
+		return hamt32.Diff(a, b, func(hamt32.Change) bool { return false })
+	}
+	merged, conflicts = hamt32.Merge3(base, base, theirs, nil)
+	if err = hamttest.Validate(merged); err != nil {
+		t.Fatalf("%s: hamt32.Merge3(base, base, theirs): %s", name, err)
+	}
+	if len(conflicts) != 0 || !same(merged, theirs) {
+		t.Fatalf("%s: hamt32.Merge3(base, base, theirs) != theirs", name)
+	}
+	merged, _ = hamt32.Merge3(base, ours, base, nil)
+	if merged != ours {
+		t.Fatalf("%s: hamt32.Merge3(base, ours, base) != ours", name)
+	}
+}
+
 func BenchmarkHamt64Put(b *testing.B) {
 	runBenchmarkHamt64Put(b, KVS64, Functional, TableOption)
 }
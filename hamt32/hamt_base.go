@@ -10,6 +10,30 @@ type hamtBase struct {
 	nentries   uint
 	nograde    bool
 	startFixed bool
+	stats      Stats
+
+	// mods counts the structural modifications made in place (by a
+	// HamtTransient), so iterations can detect them; see
+	// ConcurrentModification.
+	mods uint
+
+	// collisionSizes maps a collisionLeaf size to the number of collisionLeafs
+	// of that size. It is copy-on-write; see countCollision().
+	collisionSizes map[uint]uint
+
+	collisionHook      CollisionHook
+	collisionThreshold uint
+
+	// keyless is set for a Hamt backing a Set; new leafs are setLeafs.
+	keyless bool
+
+	// guard is set for a guarded HamtTransient; see NewGuardedTransient().
+	// ToFunctional() clears it, along with undo, so a HamtFunctional never
+	// carries either into the copies its Put() and Del() make.
+	guard *ownerGuard
+
+	// undo is set while a HamtTransient has an active Savepoint.
+	undo *undoLog
 }
 
 func (h *hamtBase) init(tblOpt int) {
@@ -48,6 +72,11 @@ func (h *hamtBase) DeepCopy() Hamt {
 	nh.nentries = h.nentries
 	nh.nograde = h.nograde
 	nh.startFixed = h.startFixed
+	nh.stats = h.stats
+	nh.collisionSizes = h.collisionSizes
+	nh.collisionHook = h.collisionHook
+	nh.collisionThreshold = h.collisionThreshold
+	nh.keyless = h.keyless
 	return nh
 }
 
@@ -137,13 +166,22 @@ DepthIter:
 	return val, found
 }
 
-func (h *hamtBase) createTable(depth uint, l1 leafI, l2 *flatLeaf) tableI {
+func (h *hamtBase) createTable(depth uint, l1, l2 leafI) tableI {
 	if h.startFixed {
 		return createFixedTable(depth, l1, l2)
 	}
 	return createSparseTable(depth, l1, l2)
 }
 
+// newLeaf creates the leaf for a key that is not yet in the Hamt; a setLeaf
+// if the Hamt is keyless, otherwise a flatLeaf.
+func (h *hamtBase) newLeaf(key KeyI, val interface{}) leafI {
+	if h.keyless {
+		return newSetLeaf(key)
+	}
+	return newFlatLeaf(key, val)
+}
+
 // String returns a string representation of the hamtBase stastructure.
 // Secifically it returns a representation of the data structure with the
 // nentries value of Nentries() and a representation of the root table.
@@ -182,7 +220,12 @@ func (h *hamtBase) walk(fn visitFn) bool {
 // Note: we say "seemingly random order", becuase there is a predictable order
 // based on the hash value of the Keys and the insertion order of the KeyVal
 // pairs, so you cannot reley on the "randomness" of the order of KeyVal pairs.
+//
+// If fn, or another goroutine, adds or removes a key of a HamtTransient
+// during the Range, Range panics with a ConcurrentModification; use an
+// Iterator to delete keys while iterating.
 func (h *hamtBase) Range(fn func(KeyI, interface{}) bool) {
+	var mods = h.mods
 	var visitLeafs = func(n nodeI) bool {
 		var keepOn = true
 
@@ -193,6 +236,9 @@ func (h *hamtBase) Range(fn func(KeyI, interface{}) bool) {
 			for _, kv := range x.keyVals() {
 				if !fn(kv.Key, kv.Val) {
 					keepOn = false
+				}
+				h.checkMods(mods, "Range")
+				if !keepOn {
 					break //for
 				}
 			}
@@ -206,6 +252,9 @@ func (h *hamtBase) Range(fn func(KeyI, interface{}) bool) {
 
 // Stats walks the Hamt in a pre-order traversal and populates a Stats data
 // struture which it returns.
+//
+// This is the slow path; see QuickStats(). It is kept because it computes
+// every count from scratch, so it can be used to verify QuickStats().
 func (h *hamtBase) Stats() *Stats {
 	var stats = new(Stats)
 
@@ -234,18 +283,20 @@ func (h *hamtBase) Stats() *Stats {
 			if x.depth > stats.MaxDepth {
 				stats.MaxDepth = x.depth
 			}
-		case *flatLeaf:
+		case *flatLeaf, *setLeaf:
 			stats.Nodes++
 			stats.Leafs++
 			stats.FlatLeafs++
 			stats.KeyVals += 1
-			keepOn = false
-		case *collisionLeaf:
+		case *collisionLeaf, *setCollisionLeaf:
+			var size, _ = collisionSize(x)
 			stats.Nodes++
 			stats.Leafs++
 			stats.CollisionLeafs++
-			stats.KeyVals += uint(len(x.kvs))
-			keepOn = false
+			stats.KeyVals += size
+			if size > stats.MaxCollisionLeafSize {
+				stats.MaxCollisionLeafSize = size
+			}
 		}
 		return keepOn
 	}
@@ -1,5 +1,10 @@
 package hamt32
 
+import (
+	"context"
+	"math/rand"
+)
+
 // HamtTransient is the data structure which the Transient Hamt methods are
 // called upon. In fact it is identical to the HamtFunctional data structure and
 // all the table and leaf data structures it uses are the same ones used by the
@@ -23,9 +28,38 @@ func NewTransient(tblOpt int) *HamtTransient {
 
 	h.hamtBase.init(tblOpt)
 
+	if GuardTransients {
+		h.guard = newOwnerGuard()
+	}
+
+	return h
+}
+
+// NewGuardedTransient constructs a new HamtTransient data structure owned by
+// the calling goroutine. If any other goroutine calls its Put(), Del(), or
+// Range() methods, they panic with an OwnershipViolation holding the stack
+// traces of both goroutines. Use Handoff() to pass the HamtTransient on to
+// another goroutine.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewGuardedTransient(tblOpt int) *HamtTransient {
+	var h = new(HamtTransient)
+	h.hamtBase.init(tblOpt)
+	h.guard = newOwnerGuard()
 	return h
 }
 
+// Handoff gives up the calling goroutine's ownership of a guarded
+// HamtTransient; the next goroutine to modify or iterate over it becomes its
+// owner. It panics if the calling goroutine is not the owner. It does
+// nothing for an unguarded HamtTransient.
+func (h *HamtTransient) Handoff() {
+	if h.guard != nil {
+		h.guard.handoff()
+	}
+}
+
 // IsEmpty simply returns if the HamtTransient datastucture has no entries.
 func (h *HamtTransient) IsEmpty() bool {
 	return h.hamtBase.IsEmpty()
@@ -43,8 +77,14 @@ func (h *HamtTransient) Nentries() uint {
 // If you want a copy of the HamtTransient data structure over to a completely
 // independent HamtFunctional data structure, you should first do a DeepCopy
 // followed by a ToFunctional call.
+//
+// A HamtFunctional has no owner and no Savepoints, so ToFunctional drops the
+// guard of a guarded HamtTransient and ends its active Savepoints.
 func (h *HamtTransient) ToFunctional() Hamt {
+	h.checkOwner("HamtTransient.ToFunctional")
 	var nh = (*HamtFunctional)(h)
+	nh.guard = nil
+	nh.undo = nil
 	return nh
 }
 
@@ -62,6 +102,11 @@ func (h *HamtTransient) DeepCopy() Hamt {
 	nh.nentries = h.nentries
 	nh.nograde = h.nograde
 	nh.startFixed = h.startFixed
+	nh.stats = h.stats
+	nh.collisionSizes = h.collisionSizes
+	nh.collisionHook = h.collisionHook
+	nh.collisionThreshold = h.collisionThreshold
+	nh.keyless = h.keyless
 	return nh
 }
 
@@ -77,6 +122,13 @@ func (h *HamtTransient) Get(key KeyI) (interface{}, bool) {
 // the value in a previously stored (key,value) pair. Either way it returns and
 // new HamtTransient data structure containing the modification.
 func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
+	h.checkOwner("HamtTransient.Put")
+
+	if h.undo != nil {
+		var old, found = h.Get(key)
+		h.undo.record(key, old, found)
+	}
+
 	// Doing this in newFlatLeaf() and leafI.put().
 
 	var hv = key.Hash()
@@ -87,6 +139,8 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 	var added bool
 
 	if leaf == nil {
+		h.removeNode(curTable)
+
 		//check if upgrading allowed & if it is required
 		if !h.nograde && curTable != &h.root &&
 			(curTable.nentries()+1) == UpgradeThreshold {
@@ -99,8 +153,12 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 
 			curTable = newTable
 		}
-		curTable.insert(idx, newFlatLeaf(key, val))
+		var newLeaf = h.newLeaf(key, val)
+		curTable.insert(idx, newLeaf)
 		added = true
+
+		h.addNode(curTable)
+		h.addNode(newLeaf)
 	} else {
 		// This is the condition that allows collision leafs to exist at a level
 		// less than maxDepth. I don't know if I want to allow this...
@@ -108,15 +166,27 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 			var newLeaf leafI
 			newLeaf, added = leaf.put(key, val)
 			curTable.replace(idx, newLeaf)
+			h.addNode(newLeaf)
+			if added {
+				h.collided(newLeaf)
+			}
 		} else {
-			var t = h.createTable(depth+1, leaf, newFlatLeaf(key, val))
+			var t = h.createTable(depth+1, leaf, h.newLeaf(key, val))
 			curTable.replace(idx, t)
 			added = true
+			h.addTree(t)
 		}
+		h.removeNode(leaf)
 	}
 
 	if added {
 		h.nentries++
+		h.mods++
+
+		incrCount(curTable, true)
+		for t := path.pop(); t != nil; t = path.pop() {
+			incrCount(t, true)
+		}
 	}
 
 	return h, added
@@ -134,6 +204,8 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 // In either case, the Hamt value is the original HamtTransient pointer as a
 // Hamt interface.
 func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
+	h.checkOwner("HamtTransient.Del")
+
 	if h.IsEmpty() {
 		return h, nil, false
 	}
@@ -154,11 +226,22 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 		return h, nil, false
 	}
 
+	if h.undo != nil {
+		h.undo.record(key, val, true)
+	}
+
 	h.nentries--
+	h.mods++
+	incrCount(curTable, false)
+
+	h.removeNode(leaf)
 
 	if newLeaf != nil { //leaf was a CollisionLeaf
 		curTable.replace(idx, newLeaf)
+		h.addNode(newLeaf)
 	} else { //leaf was a FlatLeaf
+		h.removeNode(curTable)
+
 		curTable.remove(idx)
 
 		// Side-Effects of removing an KeyVal from the table
@@ -171,6 +254,8 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 					var parentTable = path.peek()
 					var parentIdx = hv.Index(depth - 1)
 					parentTable.replace(parentIdx, lastNode)
+
+					curTable = nil
 				}
 
 				// else check if downgrade allowed and required
@@ -181,8 +266,18 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 				var parentTable = path.peek()
 				var parentIdx = hv.Index(depth - 1)
 				parentTable.replace(parentIdx, newTable)
+
+				curTable = newTable
 			}
 		}
+
+		if curTable != nil {
+			h.addNode(curTable)
+		}
+	}
+
+	for t := path.pop(); t != nil; t = path.pop() {
+		incrCount(t, false)
 	}
 
 	return h, val, deleted
@@ -208,13 +303,38 @@ func (h *HamtTransient) walk(fn visitFn) bool {
 	return h.hamtBase.walk(fn)
 }
 
+// Root returns a read-only NodeView of the root table of the HamtTransient.
+func (h *HamtTransient) Root() NodeView {
+	return h.hamtBase.Root()
+}
+
+// Walk traverses the HamtTransient calling fn with a NodeView of every table
+// and leaf (PreOrder) or of every leaf (LeafsOnly). The traversal stops, and
+// Walk returns false, if fn returns false.
+func (h *HamtTransient) Walk(mode WalkMode, fn func(NodeView) bool) bool {
+	h.checkOwner("HamtTransient.Walk")
+	return h.hamtBase.Walk(mode, fn)
+}
+
+// KeySet returns a transient Set view of the keys of the HamtTransient. It
+// shares the trie with the HamtTransient, so it costs nothing to construct. The
+// view is live; modifying the Set modifies the HamtTransient and vice versa.
+// Keys added to the Set are given a nil value.
+func (h *HamtTransient) KeySet() *Set {
+	return &Set{h}
+}
+
 // Range executes the given function for every KeyVal pair in the Hamt. KeyVal
 // pairs are visited in a seeminly random order.
 //
 // Note: we say "seemingly random order", becuase there is a predictable order
 // based on the hash value of the Keys and the insertion order of the KeyVal
 // pairs, so you cannot reley on the "randomness" of the order of KeyVal pairs.
+//
+// fn must not Put() new keys or Del() keys; Range panics with a
+// ConcurrentModification if it does. Use Iter() to delete while iterating.
 func (h *HamtTransient) Range(fn func(KeyI, interface{}) bool) {
+	h.checkOwner("HamtTransient.Range")
 	h.hamtBase.Range(fn)
 }
 
@@ -223,3 +343,168 @@ func (h *HamtTransient) Range(fn func(KeyI, interface{}) bool) {
 func (h *HamtTransient) Stats() *Stats {
 	return h.hamtBase.Stats()
 }
+
+// QuickStats returns the same Stats data structure as Stats(), but from counts
+// maintained during Put() and Del() rather than from a full walk of the Hamt.
+func (h *HamtTransient) QuickStats() *Stats {
+	return h.hamtBase.QuickStats()
+}
+
+// SetCollisionHook sets the function the HamtTransient calls whenever a
+// collisionLeaf is created, and whenever one grows to more than threshold
+// KeyVal pairs. A nil hook turns collision monitoring off. The HamtTransient is
+// modified in place and returned.
+func (h *HamtTransient) SetCollisionHook(
+	threshold uint,
+	hook CollisionHook,
+) Hamt {
+	h.checkOwner("HamtTransient.SetCollisionHook")
+	h.setCollisionHook(threshold, hook)
+	return h
+}
+
+// Nth returns the i'th KeyVal pair of the HamtTransient in hash order, the
+// order Range() visits them in, and true; or an empty KeyVal and false if
+// i >= Nentries().
+func (h *HamtTransient) Nth(i uint) (KeyVal, bool) {
+	return h.hamtBase.Nth(i)
+}
+
+// Rank returns the position of the key in hash order and true, or 0 and false
+// if the key is not in the HamtTransient.
+func (h *HamtTransient) Rank(key KeyI) (uint, bool) {
+	return h.hamtBase.Rank(key)
+}
+
+// RandomEntry returns a KeyVal pair of the HamtTransient chosen uniformly at
+// random and true, or an empty KeyVal and false if the HamtTransient is empty.
+func (h *HamtTransient) RandomEntry(src rand.Source) (KeyVal, bool) {
+	return h.hamtBase.RandomEntry(src)
+}
+
+// Sample returns n distinct KeyVal pairs of the HamtTransient chosen uniformly
+// at random, in hash order.
+func (h *HamtTransient) Sample(n uint, src rand.Source) []KeyVal {
+	return h.hamtBase.Sample(n, src)
+}
+
+// Seek returns a Cursor for the first leaf of the HamtTransient whose HashVal
+// is at or after hv in hash order and true, or the zero Cursor and false if
+// there is no such leaf.
+func (h *HamtTransient) Seek(hv HashVal) (Cursor, bool) {
+	h.checkOwner("HamtTransient.Seek")
+	return h.hamtBase.Seek(hv)
+}
+
+// RangeFrom executes the given function for every KeyVal pair of the
+// HamtTransient at or after the Cursor, in Range() order. If fn returns false
+// it returns a Cursor to resume from and false; otherwise it returns the zero
+// Cursor and true.
+func (h *HamtTransient) RangeFrom(
+	c Cursor,
+	fn func(KeyI, interface{}) bool,
+) (Cursor, bool) {
+	h.checkOwner("HamtTransient.RangeFrom")
+	return h.hamtBase.RangeFrom(c, fn)
+}
+
+// Iter returns an Iterator over the HamtTransient, positioned before the first
+// KeyVal pair. The Iterator may Delete() KeyVal pairs; any other modification
+// of the HamtTransient during the iteration makes it panic with a
+// ConcurrentModification.
+func (h *HamtTransient) Iter() *Iterator {
+	h.checkOwner("HamtTransient.Iter")
+	return h.iter(func(key KeyI) { h.Del(key) })
+}
+
+// Split divides the HamtTransient into n shards, each holding the subtrees of a
+// contiguous range of root slots; n is limited to IndexLimit. The shards are
+// HamtTransients which take over the subtrees of the original, so the original
+// must no longer be used. The shards are disjoint, so each one can be modified
+// by its own goroutine. Use Join() to put the shards back together.
+func (h *HamtTransient) Split(n uint) []Hamt {
+	return h.split(n, func() (Hamt, *hamtBase) {
+		var nh = new(HamtTransient)
+		return nh, &nh.hamtBase
+	})
+}
+
+// ParallelRange executes the given function for every KeyVal pair in the
+// HamtTransient using up to workers goroutines; see hamtBase.ParallelRange. fn
+// must be safe for concurrent use. It returns ctx.Err().
+func (h *HamtTransient) ParallelRange(
+	ctx context.Context,
+	workers int,
+	fn func(KeyI, interface{}) bool,
+) error {
+	h.checkOwner("HamtTransient.ParallelRange")
+	return h.hamtBase.ParallelRange(ctx, workers, fn)
+}
+
+// ParallelFold folds every KeyVal pair in the HamtTransient into a single
+// value, folding the subtrees of the root table concurrently and combining the
+// results in root index order.
+func (h *HamtTransient) ParallelFold(
+	ctx context.Context,
+	zero interface{},
+	fold func(acc interface{}, key KeyI, val interface{}) interface{},
+	combine func(acc, sub interface{}) interface{},
+) (interface{}, error) {
+	h.checkOwner("HamtTransient.ParallelFold")
+	return h.hamtBase.ParallelFold(ctx, zero, fold, combine)
+}
+
+// ParallelApply applies a batch of Puts and Dels to the HamtTransient. The
+// batch is partitioned by the root index of each key, and the partitions are
+// applied concurrently, each into its own subtree of the root table. The ops
+// for any one key are applied in batch order. The CollisionHook, if set, may be
+// called concurrently.
+//
+// While a Savepoint is active the batch is applied sequentially, so it can be
+// rolled back.
+func (h *HamtTransient) ParallelApply(batch []BatchOp) {
+	h.checkOwner("HamtTransient.ParallelApply")
+
+	if h.undo != nil {
+		for _, op := range batch {
+			if op.Del {
+				h.Del(op.Key)
+			} else {
+				h.Put(op.Key, op.Val)
+			}
+		}
+		return
+	}
+	h.parallelApply(batch)
+}
+
+// Savepoint marks the current state of the HamtTransient, so the modifications
+// made after it can be undone with Rollback(). Savepoints nest; each must
+// eventually be Release()d or rolled back, because until then every Put() and
+// Del() is logged.
+func (h *HamtTransient) Savepoint() Savepoint {
+	h.checkOwner("HamtTransient.Savepoint")
+	return h.savepoint()
+}
+
+// Rollback undoes every modification made to the HamtTransient since the
+// Savepoint, including those of the Savepoints made after it, and ends all of
+// them and the Savepoint itself. It panics if the Savepoint is not active.
+//
+// The HamtTransient ends up with the same KeyVal pairs it had, but not
+// necessarily the same tables; just as if the modifications had been undone by
+// hand.
+func (h *HamtTransient) Rollback(sp Savepoint) {
+	h.checkOwner("HamtTransient.Rollback")
+	h.rollback(sp,
+		func(key KeyI, val interface{}) { h.Put(key, val) },
+		func(key KeyI) { h.Del(key) })
+}
+
+// Release ends the Savepoint, and the Savepoints made after it, keeping their
+// modifications. They can still be undone by rolling back to an earlier
+// Savepoint. It panics if the Savepoint is not active.
+func (h *HamtTransient) Release(sp Savepoint) {
+	h.checkOwner("HamtTransient.Release")
+	h.release(sp)
+}
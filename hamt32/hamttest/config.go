@@ -0,0 +1,151 @@
+/*
+Package hamttest provides a conformance test kit for code built on the hamt32
+package: for KeyI implementations, for Hamt implementations, and for the test
+suites of either.
+
+CheckKey verifies that a KeyI implementation honors the Equals/Hash contract.
+
+CheckModel drives a Hamt through a long random sequence of Put, Del, Get, and
+ToTransient/ToFunctional operations and compares every result against a Go map
+holding the same KeyVal pairs. Validate checks the structural invariants of a
+Hamt via the Hamt Walk/NodeView API.
+
+CollidingKey and the key generators force the otherwise rare collisionLeaf and
+deep table code paths.
+
+Config and Flags are the -F/-S/-H/-A and -f/-t/-b command line flag plumbing
+used by the hamt32 tests, so other test suites can run against the same
+combinations of table option and functional/transient behavior.
+*/
+package hamttest
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/lleo/go-hamt/hamt32"
+)
+
+// Config is one combination of the functional/transient behavior and the
+// table option that a Hamt can be constructed with.
+type Config struct {
+	Functional  bool
+	TableOption int
+}
+
+// New constructs an empty Hamt with the Config's settings.
+func (c Config) New() hamt32.Hamt {
+	return hamt32.New(c.Functional, c.TableOption)
+}
+
+// String returns the Config in the form the hamt32 tests use to name
+// themselves; eg. "functional:HybridTables".
+func (c Config) String() string {
+	if c.Functional {
+		return "functional:" + hamt32.TableOptionName[c.TableOption]
+	}
+	return "transient:" + hamt32.TableOptionName[c.TableOption]
+}
+
+// AllConfigs lists every Config in the order the hamt32 tests run them:
+// transient before functional, and SparseTables, FixedTables, then
+// HybridTables for each.
+var AllConfigs = []Config{
+	{false, hamt32.SparseTables},
+	{false, hamt32.FixedTables},
+	{false, hamt32.HybridTables},
+	{true, hamt32.SparseTables},
+	{true, hamt32.FixedTables},
+	{true, hamt32.HybridTables},
+}
+
+// ErrFlags is returned by Flags.Configs() when mutually exclusive flags were
+// given together.
+var ErrFlags = errors.New("hamttest: mutually exclusive flags given")
+
+// Flags holds the values of the table option flags (-F, -S, -H, and -A) and
+// the behavior flags (-f, -t, and -b).
+type Flags struct {
+	fixedonly, sparseonly, hybrid, all bool
+	functional, transient, both        bool
+}
+
+// RegisterFlags defines the table option and behavior flags in the given
+// FlagSet (usually flag.CommandLine) and returns the Flags they will be
+// parsed into.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	var f = new(Flags)
+
+	fs.BoolVar(&f.fixedonly, "F", false,
+		"Use fixed tables only and exclude S and H Options.")
+	fs.BoolVar(&f.sparseonly, "S", false,
+		"Use sparse tables only and exclude F and H Options.")
+	fs.BoolVar(&f.hybrid, "H", false,
+		"Use sparse tables initially and exclude F and S Options.")
+	fs.BoolVar(&f.all, "A", false,
+		"Run all Tests w/ Options set to FixedTables, SparseTables, and HybridTables")
+
+	fs.BoolVar(&f.functional, "f", false,
+		"Run Tests against HamtFunctional struct; excludes transient option")
+	fs.BoolVar(&f.transient, "t", false,
+		"Run Tests against HamtTransient struct; excludes functional option")
+	fs.BoolVar(&f.both, "b", false,
+		"Run Tests against both transient and functional Hamt types.")
+
+	return f
+}
+
+// Configs returns the Configs selected by the parsed flags, in the order of
+// AllConfigs. No table option flag means all of them (-A), and no behavior
+// flag means both (-b).
+func (f *Flags) Configs() ([]Config, error) {
+	var all, both = f.all, f.both
+
+	if !all {
+		// only one flag may be set between fixedonly, sparseonly, and hybrid
+		if (f.fixedonly && (f.sparseonly || f.hybrid)) ||
+			(f.sparseonly && (f.fixedonly || f.hybrid)) ||
+			(f.hybrid && (f.sparseonly || f.fixedonly)) {
+			return nil, ErrFlags
+		}
+	}
+
+	// If no flags given, run all tests.
+	if !(all || f.fixedonly || f.sparseonly || f.hybrid) {
+		all = true
+	}
+
+	if !both && f.functional && f.transient {
+		return nil, ErrFlags
+	}
+
+	if !(both || f.functional || f.transient) {
+		both = true
+	}
+
+	var configs []Config
+	for _, c := range AllConfigs {
+		if !both && c.Functional != f.functional {
+			continue
+		}
+		if !all {
+			switch c.TableOption {
+			case hamt32.FixedTables:
+				if !f.fixedonly {
+					continue
+				}
+			case hamt32.SparseTables:
+				if !f.sparseonly {
+					continue
+				}
+			case hamt32.HybridTables:
+				if !f.hybrid {
+					continue
+				}
+			}
+		}
+		configs = append(configs, c)
+	}
+
+	return configs, nil
+}
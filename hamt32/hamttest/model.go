@@ -0,0 +1,272 @@
+package hamttest
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/lleo/go-hamt/hamt32"
+)
+
+// CheckModel drives the Hamt h through nops randomly chosen operations on keys
+// drawn from the keys slice, and checks every result against a Go map used as
+// a model of what the Hamt should contain. The keys must be distinct; mixing
+// in CollidingKeys() and DeepKeys() exercises the rarer code paths.
+//
+// The operations are Put (of a key that may or may not already be present),
+// Del, Get, and switching between the functional and transient behavior with
+// ToTransient() and ToFunctional(). Every so often, and at the end, the Hamt is
+// checked with Validate() and its Range() compared with the model.
+//
+// CheckModel returns the final Hamt so the caller can examine it further.
+func CheckModel(
+	t testing.TB,
+	h hamt32.Hamt,
+	keys []hamt32.KeyI,
+	nops int,
+	rnd *rand.Rand,
+) hamt32.Hamt {
+	t.Helper()
+
+	var model = make(map[int]int) // index into keys -> value
+	var functional = isFunctional(h)
+
+	var check = func(op int) {
+		t.Helper()
+		if err := Validate(h); err != nil {
+			t.Fatalf("CheckModel: op %d: %s", op, err)
+		}
+		if err := compareRange(h, keys, model); err != nil {
+			t.Fatalf("CheckModel: op %d: %s", op, err)
+		}
+	}
+
+	for op := 0; op < nops; op++ {
+		var i = rnd.Intn(len(keys))
+		var key = keys[i]
+		var expected, present = model[i]
+
+		switch r := rnd.Intn(100); {
+		case r < 45:
+			var added bool
+			h, added = h.Put(key, op)
+			if added == present {
+				t.Fatalf("CheckModel: op %d: Put(%v) added=%t; key present=%t",
+					op, key, added, present)
+			}
+			model[i] = op
+
+		case r < 75:
+			var val interface{}
+			var deleted bool
+			h, val, deleted = h.Del(key)
+			if deleted != present {
+				t.Fatalf("CheckModel: op %d: Del(%v) deleted=%t; key present=%t",
+					op, key, deleted, present)
+			}
+			if deleted && val != expected {
+				t.Fatalf("CheckModel: op %d: Del(%v) val=%v; expected %d",
+					op, key, val, expected)
+			}
+			delete(model, i)
+
+		case r < 97:
+			var val, found = h.Get(key)
+			if found != present {
+				t.Fatalf("CheckModel: op %d: Get(%v) found=%t; key present=%t",
+					op, key, found, present)
+			}
+			if found && val != expected {
+				t.Fatalf("CheckModel: op %d: Get(%v) val=%v; expected %d",
+					op, key, val, expected)
+			}
+
+		default:
+			if functional {
+				h = h.ToTransient()
+			} else {
+				h = h.ToFunctional()
+			}
+			functional = !functional
+		}
+
+		if h.Nentries() != uint(len(model)) {
+			t.Fatalf("CheckModel: op %d: Nentries()=%d; expected %d",
+				op, h.Nentries(), len(model))
+		}
+
+		if op%1000 == 999 {
+			check(op)
+		}
+	}
+
+	check(nops)
+
+	return h
+}
+
+func isFunctional(h hamt32.Hamt) bool {
+	var _, functional = h.(*hamt32.HamtFunctional)
+	return functional
+}
+
+// compareRange checks that Range() visits exactly the KeyVal pairs in the
+// model, each once.
+func compareRange(h hamt32.Hamt, keys []hamt32.KeyI, model map[int]int) error {
+	var index = make(map[hamt32.KeyI]int, len(model))
+	for i := range model {
+		index[keys[i]] = i
+	}
+
+	var err error
+	var seen = make(map[int]bool, len(model))
+	h.Range(func(k hamt32.KeyI, v interface{}) bool {
+		var i, found = index[k]
+		switch {
+		case !found:
+			err = fmt.Errorf("Range() visited key %v not in the model", k)
+		case seen[i]:
+			err = fmt.Errorf("Range() visited key %v twice", k)
+		case v != model[i]:
+			err = fmt.Errorf("Range() key %v val=%v; expected %d",
+				k, v, model[i])
+		}
+		seen[i] = true
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(seen) != len(model) {
+		return fmt.Errorf("Range() visited %d keys; expected %d",
+			len(seen), len(model))
+	}
+
+	return nil
+}
+
+// Validate checks the structural invariants of a Hamt and returns an error
+// describing the first one that is violated, or nil. It checks that:
+//   - every node is in the slot its HashVal indexes at its parent's depth, and
+//     its depth is one more than its parent's;
+//   - no table is deeper than DepthLimit-1;
+//   - every key in a leaf has the leaf's HashVal, a collisionLeaf holds at
+//     least two keys, and no two keys in a leaf are equal;
+//   - Get() finds every key in the Hamt, and Rank() and Nth() agree with
+//     the position of the key in hash order;
+//   - the Count() of every table is the number of KeyVal pairs under it;
+//   - the number of KeyVal pairs equals Nentries();
+//   - QuickStats() equals Stats().
+func Validate(h hamt32.Hamt) error {
+	var nkvs uint
+	var err = validateTable(h, h.Root(), &nkvs)
+	if err != nil {
+		return err
+	}
+
+	if nkvs != h.Nentries() {
+		return fmt.Errorf("Validate: found %d KeyVals; Nentries()=%d",
+			nkvs, h.Nentries())
+	}
+
+	var stats, quick = h.Stats(), h.QuickStats()
+	if *stats != *quick {
+		return fmt.Errorf("Validate: QuickStats()=%+v != Stats()=%+v",
+			quick, stats)
+	}
+
+	return nil
+}
+
+func validateTable(h hamt32.Hamt, t hamt32.NodeView, nkvs *uint) error {
+	if t.Depth() >= hamt32.DepthLimit {
+		return fmt.Errorf("Validate: table %s is deeper than DepthLimit-1", t)
+	}
+
+	var start = *nkvs
+
+	var children = t.Children()
+	if uint(len(children)) != t.Nentries() {
+		return fmt.Errorf("Validate: table %s has %d children; Nentries()=%d",
+			t, len(children), t.Nentries())
+	}
+
+	for _, c := range children {
+		var idx = c.Index()
+		if c2, found := t.Child(idx); !found || c2.Kind() != c.Kind() {
+			return fmt.Errorf("Validate: table %s child %s not in slot %d",
+				t, c, idx)
+		}
+		if c.Depth() != t.Depth()+1 {
+			return fmt.Errorf("Validate: child %s of table %s at wrong depth",
+				c, t)
+		}
+		for d := uint(0); d < t.Depth(); d++ {
+			if c.Hash().Index(d) != t.HashPath().Index(d) {
+				return fmt.Errorf("Validate: child %s does not share the "+
+					"hashPath of table %s", c, t)
+			}
+		}
+
+		var err error
+		if c.IsTable() {
+			err = validateTable(h, c, nkvs)
+		} else {
+			err = validateLeaf(h, c, nkvs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if *nkvs-start != t.Count() {
+		return fmt.Errorf("Validate: table %s Count()=%d; holds %d KeyVals",
+			t, t.Count(), *nkvs-start)
+	}
+
+	return nil
+}
+
+func validateLeaf(h hamt32.Hamt, l hamt32.NodeView, nkvs *uint) error {
+	var kvs = l.KeyVals()
+
+	if l.Kind() == hamt32.CollisionLeafKind && len(kvs) < 2 {
+		return fmt.Errorf("Validate: collisionLeaf %s has %d keys", l, len(kvs))
+	}
+
+	for i, kv := range kvs {
+		if kv.Key.Hash() != l.Hash() {
+			return fmt.Errorf("Validate: key %v in leaf %s has hash %s",
+				kv.Key, l, kv.Key.Hash())
+		}
+		for _, kv2 := range kvs[:i] {
+			if kv.Key.Equals(kv2.Key) {
+				return fmt.Errorf("Validate: leaf %s holds key %v twice",
+					l, kv.Key)
+			}
+		}
+		if _, found := h.Get(kv.Key); !found {
+			return fmt.Errorf("Validate: Get(%v) failed for key in leaf %s",
+				kv.Key, l)
+		}
+
+		var o, isOrdered = h.(hamt32.Ordered)
+		if !isOrdered {
+			continue
+		}
+		var pos = *nkvs + uint(i)
+		if rank, found := o.Rank(kv.Key); !found || rank != pos {
+			return fmt.Errorf("Validate: Rank(%v)=%d,%t; expected %d",
+				kv.Key, rank, found, pos)
+		}
+		if nth, found := o.Nth(pos); !found || !nth.Key.Equals(kv.Key) {
+			return fmt.Errorf("Validate: Nth(%d)=%v,%t; expected key %v",
+				pos, nth, found, kv.Key)
+		}
+	}
+
+	*nkvs += uint(len(kvs))
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package hamt32
+
+import "fmt"
+
+// ConcurrentModification is the value Range(), RangeFrom(), and an Iterator
+// panic with when the HamtTransient they are iterating over gets a key added
+// or removed other than by Iterator.Delete(). That would otherwise silently
+// corrupt the iteration, because the tables are modified in place.
+//
+// It is only a best effort to detect the mistake, like Java's fail-fast
+// iterators; a modification by another goroutine is a data race and may go
+// unnoticed.
+type ConcurrentModification struct {
+	Op string
+}
+
+func (e ConcurrentModification) Error() string {
+	return fmt.Sprintf("%s: Hamt modified during iteration", e.Op)
+}
+
+// checkMods panics with a ConcurrentModification if h was modified since
+// h.mods was mods.
+func (h *hamtBase) checkMods(mods uint, op string) {
+	if h.mods != mods {
+		panic(ConcurrentModification{op})
+	}
+}
+
+// Iterator steps through the KeyVal pairs of a Hamt in Range() order.
+//
+//	for it := h.Iter(); it.Next(); {
+//		if shouldGo(it.Key(), it.Val()) {
+//			it.Delete()
+//		}
+//	}
+//
+// An Iterator over a HamtTransient can Delete() the current key. Any other
+// modification of the HamtTransient, until the iteration is done, makes the
+// Iterator panic with a ConcurrentModification.
+type Iterator struct {
+	h    *hamtBase
+	del  func(KeyI) // nil for a HamtFunctional
+	mods uint
+
+	kvs  []KeyVal // the KeyVals of the current leaf
+	hv   HashVal  // the HashVal of the current leaf
+	i    int      // the index of the current KeyVal in kvs
+	gone bool     // the current KeyVal was deleted
+	done bool
+}
+
+func (h *hamtBase) iter(del func(KeyI)) *Iterator {
+	return &Iterator{h: h, del: del, mods: h.mods, i: -1}
+}
+
+// Next advances the Iterator to the next KeyVal pair. It returns false when
+// there are no more; it must be called before the first Key() or Val().
+func (it *Iterator) Next() bool {
+	it.h.checkOwner("Iterator.Next")
+	it.h.checkMods(it.mods, "Iterator.Next")
+	if it.done {
+		return false
+	}
+
+	it.gone = false
+	if it.i+1 < len(it.kvs) {
+		it.i++
+		return true
+	}
+
+	// The current leaf is done; find the next one starting right after the
+	// last KeyVal of the current one. Seeking by HashVal rather than keeping
+	// a path of tables means Delete() can restructure the tables freely.
+	var c = Cursor{it.hv, uint(len(it.kvs))}
+	var found bool
+	it.h.rangeFrom(&it.h.root, 0, c, it.kvs != nil,
+		func(l leafI, start uint) bool {
+			var kvs = l.keyVals()
+			if start >= uint(len(kvs)) {
+				return true
+			}
+			it.kvs = kvs
+			it.hv = l.Hash()
+			it.i = int(start)
+			found = true
+			return false
+		})
+
+	if !found {
+		it.kvs = nil
+		it.i = -1
+		it.done = true
+	}
+	return found
+}
+
+func (it *Iterator) current(op string) KeyVal {
+	it.h.checkMods(it.mods, op)
+	if it.i < 0 || it.gone {
+		panic(op + ": no current KeyVal")
+	}
+	return it.kvs[it.i]
+}
+
+// Key returns the key of the current KeyVal pair.
+func (it *Iterator) Key() KeyI {
+	return it.current("Iterator.Key").Key
+}
+
+// Val returns the value of the current KeyVal pair.
+func (it *Iterator) Val() interface{} {
+	return it.current("Iterator.Val").Val
+}
+
+// Delete removes the current KeyVal pair from the HamtTransient; Next() then
+// continues with the KeyVal pair after it. Delete panics for an Iterator over
+// a HamtFunctional.
+func (it *Iterator) Delete() {
+	var kv = it.current("Iterator.Delete")
+	if it.del == nil {
+		panic("Iterator.Delete: Hamt is not a HamtTransient")
+	}
+
+	it.del(kv.Key)
+	it.mods = it.h.mods
+
+	// The leaf lost the KeyVal, so the ones after it shift down by one;
+	// drop it from our copy as well, without touching the leaf's slice.
+	var kvs = make([]KeyVal, 0, len(it.kvs)-1)
+	kvs = append(kvs, it.kvs[:it.i]...)
+	it.kvs = append(kvs, it.kvs[it.i+1:]...)
+	it.i--
+	it.gone = true
+}
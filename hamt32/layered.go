@@ -0,0 +1,191 @@
+package hamt32
+
+// Layered is a stack of Hamt layers, like the nested scopes of an
+// interpreter. Get() looks through the layers from the top down; Put() and
+// Del() only modify the top layer, the local Hamt. A Del() of a key that is
+// still visible in a lower layer puts a tombstone in the local Hamt, which
+// hides the key from Get() and Range().
+//
+// Push() and Pop() are O(1); they just add or drop a local Hamt.
+//
+// A Layered is functional or transient depending on its bottom Hamt. A
+// functional Put() or Del() returns a new Layered sharing every layer but the
+// local Hamt with the original; a transient Put() or Del() modifies the local
+// Hamt in place and returns the original Layered.
+type Layered struct {
+	local  Hamt
+	parent *Layered
+	depth  uint
+}
+
+// tombstone is the value the local Hamt of a Layered maps a deleted key to.
+type tombstone struct{}
+
+// NewLayered constructs a Layered with the base Hamt as its only layer.
+func NewLayered(base Hamt) *Layered {
+	return &Layered{local: base}
+}
+
+// newLayer returns an empty Hamt of the same variety (functional or
+// transient) and table option as h.
+func newLayer(h Hamt) Hamt {
+	switch x := h.(type) {
+	case *HamtFunctional:
+		var nh = new(HamtFunctional)
+		nh.nograde = x.nograde
+		nh.startFixed = x.startFixed
+		return nh
+	case *HamtTransient:
+		var nh = new(HamtTransient)
+		nh.nograde = x.nograde
+		nh.startFixed = x.startFixed
+		return nh
+	}
+	return New(true, HybridTables)
+}
+
+// Push returns a new Layered with an empty local Hamt on top of l. The
+// original Layered is unaltered.
+func (l *Layered) Push() *Layered {
+	return &Layered{local: newLayer(l.local), parent: l, depth: l.depth + 1}
+}
+
+// Pop returns the Layered below l, or nil if l is the bottom layer.
+func (l *Layered) Pop() *Layered {
+	return l.parent
+}
+
+// Depth returns the number of layers below the local Hamt.
+func (l *Layered) Depth() uint {
+	return l.depth
+}
+
+// Local returns the local Hamt of the top layer. Deleted keys that are
+// visible in a lower layer are mapped to an unexported tombstone value.
+func (l *Layered) Local() Hamt {
+	return l.local
+}
+
+// Get retrieves the value related to the key in the top-most layer that has
+// the key. It also returns a bool to indicate the value was found; a key
+// deleted in a higher layer is not found.
+func (l *Layered) Get(key KeyI) (interface{}, bool) {
+	for x := l; x != nil; x = x.parent {
+		if val, found := x.local.Get(key); found {
+			if _, deleted := val.(tombstone); deleted {
+				return nil, false
+			}
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// Put stores the (key,value) pair in the local Hamt. It returns the resulting
+// Layered and a bool indicating if the key was not previously visible (true)
+// or if its value was shadowed or replaced (false).
+func (l *Layered) Put(key KeyI, val interface{}) (*Layered, bool) {
+	var _, visible = l.Get(key)
+	var local, _ = l.local.Put(key, val)
+	return l.withLocal(local), !visible
+}
+
+// Del hides the key. If a lower layer still has the key a tombstone is put in
+// the local Hamt, otherwise the key is just deleted from the local Hamt.
+//
+// Del returns the resulting Layered, the value the key was related to, and
+// true; or, if the key was not visible, the original Layered, nil, and false.
+func (l *Layered) Del(key KeyI) (*Layered, interface{}, bool) {
+	var val, visible = l.Get(key)
+	if !visible {
+		return l, nil, false
+	}
+
+	var local Hamt
+	if _, inParent := l.parent.get(key); inParent {
+		local, _ = l.local.Put(key, tombstone{})
+	} else {
+		local, _, _ = l.local.Del(key)
+	}
+
+	return l.withLocal(local), val, true
+}
+
+// get is Get() for a possibly nil Layered.
+func (l *Layered) get(key KeyI) (interface{}, bool) {
+	if l == nil {
+		return nil, false
+	}
+	return l.Get(key)
+}
+
+// withLocal returns the Layered with local as its local Hamt. A transient
+// Layered keeps its identity.
+func (l *Layered) withLocal(local Hamt) *Layered {
+	if _, isFunctional := local.(*HamtFunctional); isFunctional {
+		return &Layered{local: local, parent: l.parent, depth: l.depth}
+	}
+	l.local = local
+	return l
+}
+
+// Range executes the given function for every visible (key,value) pair, from
+// the top layer down. A key shadowed by a higher layer is only visited once,
+// with its visible value. The iteration stops if fn returns false.
+func (l *Layered) Range(fn func(KeyI, interface{}) bool) {
+	var seen = NewSet(false, HybridTables)
+	for x := l; x != nil; x = x.parent {
+		var keepOn = true
+		x.local.Range(func(key KeyI, val interface{}) bool {
+			var added bool
+			if x.parent != nil {
+				seen, added = seen.Add(key)
+			} else {
+				added = !seen.Contains(key)
+			}
+			if !added {
+				return true //shadowed
+			}
+			if _, deleted := val.(tombstone); deleted {
+				return true
+			}
+			keepOn = fn(key, val)
+			return keepOn
+		})
+		if !keepOn {
+			return
+		}
+	}
+}
+
+// Flatten collapses the layers into a single Hamt holding the visible
+// (key,value) pairs and no tombstones.
+//
+// The bottom Hamt is the starting point; for a functional Layered it is
+// shared, for a transient Layered it is deep copied first. Each higher layer
+// is then applied in turn, so the cost is proportional to the size of the
+// layers above the bottom one.
+func (l *Layered) Flatten() Hamt {
+	var layers []Hamt
+	for x := l; x != nil; x = x.parent {
+		layers = append(layers, x.local)
+	}
+
+	var h = layers[len(layers)-1]
+	if _, isFunctional := h.(*HamtFunctional); !isFunctional {
+		h = h.DeepCopy()
+	}
+
+	for i := len(layers) - 2; i >= 0; i-- {
+		layers[i].Range(func(key KeyI, val interface{}) bool {
+			if _, deleted := val.(tombstone); deleted {
+				h, _, _ = h.Del(key)
+			} else {
+				h, _ = h.Put(key, val)
+			}
+			return true
+		})
+	}
+
+	return h
+}
@@ -21,6 +21,16 @@ type leafI interface {
 	keyVals() []KeyVal
 }
 
+// mergeLeafs combines two leafs whose keys collide into one leaf of the same
+// variety (keyed or keyless) as leaf1.
+func mergeLeafs(leaf1, leaf2 leafI) leafI {
+	var nl = leaf1
+	for _, kv := range leaf2.keyVals() {
+		nl, _ = nl.put(kv.Key, kv.Val)
+	}
+	return nl
+}
+
 type tableIterFunc func() nodeI
 
 type tableI interface {
@@ -34,6 +44,10 @@ type tableI interface {
 	nentries() uint
 	entries() []tableEntry
 
+	// count is the number of KeyVal pairs in the subtree rooted at the table.
+	count() uint
+	setCount(uint)
+
 	get(idx uint) nodeI
 
 	insert(idx uint, n nodeI)
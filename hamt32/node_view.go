@@ -0,0 +1,303 @@
+package hamt32
+
+import "fmt"
+
+// NodeKind identifies which of the four kinds of node a NodeView is looking
+// at, or NoneKind if it is looking at nothing.
+type NodeKind int
+
+const (
+	// FixedTableKind is an interior node implemented as a fixed size array of
+	// IndexLimit slots.
+	FixedTableKind NodeKind = iota
+	// SparseTableKind is an interior node implemented as a bitmap and a slice
+	// of only the occupied slots.
+	SparseTableKind
+	// FlatLeafKind is a leaf holding a single KeyVal pair.
+	FlatLeafKind
+	// CollisionLeafKind is a leaf holding two or more KeyVal pairs whose keys
+	// all have the same HashVal.
+	CollisionLeafKind
+	// NoneKind is the kind of a NodeView that is not looking at any node,
+	// like the zero value of the NodeView of this package.
+	NoneKind
+)
+
+// NodeKindName is a lookup table to map a NodeKind to a string.
+//
+//	hamt32.NodeKindName[hamt32.SparseTableKind] == "SparseTable"
+var NodeKindName = [5]string{
+	FixedTableKind:    "FixedTable",
+	SparseTableKind:   "SparseTable",
+	FlatLeafKind:      "FlatLeaf",
+	CollisionLeafKind: "CollisionLeaf",
+	NoneKind:          "None",
+}
+
+// String returns the NodeKindName of the NodeKind.
+func (k NodeKind) String() string {
+	return NodeKindName[k]
+}
+
+// NodeView is a read-only view of a table or leaf of a Hamt. It is meant for
+// tools like visualizers and auditors which need to look at the shape of the
+// Hamt rather than just the KeyVal pairs.
+//
+// The Hamts of this package return NodeViews of their own. A Hamt implemented
+// outside of it implements NodeView for its own nodes; the methods below
+// document what each must return.
+//
+// A NodeView of a HamtFunctional or a CtrieSnapshot stays valid, and
+// unchanging, forever. A NodeView of a HamtTransient is only valid until the
+// next Put() or Del().
+type NodeView interface {
+	// Kind returns which kind of table or leaf the NodeView is looking at.
+	Kind() NodeKind
+	// IsTable returns true if the NodeView is looking at a table.
+	IsTable() bool
+	// IsLeaf returns true if the NodeView is looking at a leaf.
+	IsLeaf() bool
+	// Depth returns the depth of the node; 0 for the root table.
+	Depth() uint
+	// Index returns the index of the slot the node occupies in its parent
+	// table; 0 for the root table.
+	Index() uint
+	// HashPath returns the part of the HashVal that leads from the root
+	// table to the node.
+	HashPath() HashVal
+	// Hash returns the HashVal of the keys of a leaf, or the HashPath() of
+	// a table.
+	Hash() HashVal
+	// Nentries returns the number of occupied slots of a table, or the
+	// number of KeyVal pairs of a leaf.
+	Nentries() uint
+	// Count returns the number of KeyVal pairs in the subtree rooted at the
+	// node.
+	Count() uint
+	// Child returns a NodeView of the node in the idx slot of a table and
+	// true, or nil and false if there is none.
+	Child(idx uint) (NodeView, bool)
+	// Children returns NodeViews of every node in a table ordered by slot
+	// index, or nil for a leaf.
+	Children() []NodeView
+	// KeyVals returns the KeyVal pairs stored in a leaf, or nil for a table.
+	KeyVals() []KeyVal
+	// String returns a short description of the node.
+	String() string
+}
+
+// nodeView is the NodeView of the Hamts of this package. The zero nodeView
+// looks at nothing; its Kind() is NoneKind.
+type nodeView struct {
+	node  nodeI
+	depth uint
+
+	// ctrie is set for a nodeView of a CtrieSnapshot, whose tables hold
+	// iNodes in place of their child tables.
+	ctrie *Ctrie
+}
+
+// child returns a nodeView of n, a node held by the table the nodeView is
+// looking at; an iNode is resolved to the table, or leaf, it points to.
+func (v nodeView) child(n nodeI) nodeView {
+	if in, isINode := n.(*iNode); isINode {
+		n = v.ctrie.resolve(in)
+	}
+	return nodeView{n, v.depth + 1, v.ctrie}
+}
+
+// Kind returns which kind of table or leaf the NodeView is looking at.
+func (v nodeView) Kind() NodeKind {
+	switch v.node.(type) {
+	case *fixedTable:
+		return FixedTableKind
+	case *sparseTable:
+		return SparseTableKind
+	case *flatLeaf, *setLeaf:
+		return FlatLeafKind
+	case *collisionLeaf, *setCollisionLeaf:
+		return CollisionLeafKind
+	}
+	return NoneKind
+}
+
+// IsTable returns true if the NodeView is looking at a table (an interior
+// node).
+func (v nodeView) IsTable() bool {
+	var _, isTable = v.node.(tableI)
+	return isTable
+}
+
+// IsLeaf returns true if the NodeView is looking at a leaf.
+func (v nodeView) IsLeaf() bool {
+	var _, isLeaf = v.node.(leafI)
+	return isLeaf
+}
+
+// Depth returns the depth of the node. The root table has depth 0, the nodes
+// stored in the root table have depth 1, and so on. For tables this is the
+// depth the table uses to index its slots; for leafs it is one more than the
+// depth of the table holding the leaf.
+func (v nodeView) Depth() uint {
+	return v.depth
+}
+
+// Index returns the index of the slot the node occupies in its parent table.
+// The root table has no parent, so its Index is 0.
+func (v nodeView) Index() uint {
+	if v.depth == 0 || v.node == nil {
+		return 0
+	}
+	return v.node.Hash().Index(v.depth - 1)
+}
+
+// HashPath returns the part of the HashVal that leads from the root table to
+// this node; the index values at depth Depth() and deeper are zero.
+func (v nodeView) HashPath() HashVal {
+	if v.IsTable() {
+		return v.node.Hash()
+	}
+	return v.Hash() & hashPathMask(v.depth)
+}
+
+// Hash returns the full HashVal of the keys of a leaf. For a table it is the
+// same as HashPath(). It is 0 for the zero nodeView.
+func (v nodeView) Hash() HashVal {
+	if v.node == nil {
+		return 0
+	}
+	return v.node.Hash()
+}
+
+// Nentries returns the number of occupied slots of a table, or the number of
+// KeyVal pairs of a leaf.
+func (v nodeView) Nentries() uint {
+	if v.node == nil {
+		return 0
+	}
+	if t, isTable := v.node.(tableI); isTable {
+		return t.nentries()
+	}
+	if size, isCollision := collisionSize(v.node); isCollision {
+		return size
+	}
+	return 1
+}
+
+// Child returns a NodeView of the node in the idx slot of a table and true, or
+// nil and false if the slot is empty or the NodeView is looking at a leaf.
+func (v nodeView) Child(idx uint) (NodeView, bool) {
+	var t, isTable = v.node.(tableI)
+	if !isTable || idx >= IndexLimit {
+		return nil, false
+	}
+
+	var n = t.get(idx)
+	if n == nil {
+		return nil, false
+	}
+
+	return v.child(n), true
+}
+
+// Children returns NodeViews of every node in a table ordered by slot index.
+// It returns nil for a leaf, and for the zero nodeView.
+func (v nodeView) Children() []NodeView {
+	var children []NodeView
+	for _, c := range v.children() {
+		children = append(children, c)
+	}
+	return children
+}
+
+// children returns the nodeViews Children() returns.
+func (v nodeView) children() []nodeView {
+	var t, isTable = v.node.(tableI)
+	if !isTable {
+		return nil
+	}
+
+	var ents = t.entries()
+	var children = make([]nodeView, len(ents))
+	for i, ent := range ents {
+		children[i] = v.child(ent.node)
+	}
+
+	return children
+}
+
+// KeyVals returns the KeyVal pairs stored in a leaf. It returns nil for a
+// table.
+func (v nodeView) KeyVals() []KeyVal {
+	if l, isLeaf := v.node.(leafI); isLeaf {
+		return l.keyVals()
+	}
+	return nil
+}
+
+// String returns a short description of the node.
+func (v nodeView) String() string {
+	return fmt.Sprintf("NodeView{kind: %s, depth: %d, hashPath: %s, node: %s}",
+		v.Kind(), v.depth, v.HashPath().HashPathString(v.pathLen()), v.node)
+}
+
+// pathLen is the number of index values in the HashPath of the node; this is
+// capped at DepthLimit for a leaf held by a table at maxDepth.
+func (v nodeView) pathLen() uint {
+	if v.depth > DepthLimit {
+		return DepthLimit
+	}
+	return v.depth
+}
+
+// WalkMode selects which nodes Walk() passes to its function.
+type WalkMode int
+
+const (
+	// PreOrder visits every table before the nodes it holds, and every leaf.
+	PreOrder WalkMode = iota
+	// LeafsOnly visits only the leafs.
+	LeafsOnly
+)
+
+// walk implements Walk() recursively.
+func (v nodeView) walk(mode WalkMode, fn func(nodeView) bool) bool {
+	if v.node == nil {
+		return true
+	}
+
+	var t, isTable = v.node.(tableI)
+	if !isTable {
+		return fn(v)
+	}
+
+	if mode == PreOrder && !fn(v) {
+		return false
+	}
+
+	for _, ent := range t.entries() {
+		if !v.child(ent.node).walk(mode, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Root returns a NodeView of the root table of the Hamt.
+func (h *hamtBase) Root() NodeView {
+	return h.rootView()
+}
+
+func (h *hamtBase) rootView() nodeView {
+	return nodeView{&h.root, 0, nil}
+}
+
+// Walk traverses the Hamt calling fn with a NodeView of each node selected by
+// mode, in the same order Range() visits the KeyVal pairs. Unlike walk(), it
+// never calls fn for empty slots.
+//
+// The traversal stops if fn returns false, in which case Walk returns false.
+func (h *hamtBase) Walk(mode WalkMode, fn func(NodeView) bool) bool {
+	return h.rootView().walk(mode, func(v nodeView) bool { return fn(v) })
+}
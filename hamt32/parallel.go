@@ -0,0 +1,244 @@
+package hamt32
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// The subtrees of the root table are independent of each other, so they can
+// be scanned, or modified, by separate goroutines. ParallelRange() and
+// ParallelFold() hand the subtrees out to a pool of workers. ParallelApply()
+// gives each root slot with work to do its own temporary HamtTransient, so
+// the workers never share a hamtBase, and merges the results back in.
+
+// BatchOp is one operation of the batch passed to ParallelApply(). It is a
+// Put(Key, Val), or a Del(Key) if Del is true.
+type BatchOp struct {
+	Key KeyI
+	Val interface{}
+	Del bool
+}
+
+// rangeNode calls fn for every KeyVal pair in the subtree rooted at n, in
+// Range() order. It returns false if fn did or if ctx is done.
+func rangeNode(
+	ctx context.Context,
+	n nodeI,
+	fn func(KeyI, interface{}) bool,
+) bool {
+	return n.visit(func(n nodeI) bool {
+		var l, isLeaf = n.(leafI)
+		if !isLeaf {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+
+		for _, kv := range l.keyVals() {
+			if !fn(kv.Key, kv.Val) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// forEachSubtree runs work, on up to workers goroutines, for each subtree of
+// the root table. If work returns false, or ctx is done, no more subtrees are
+// handed out. It returns ctx.Err().
+func (h *hamtBase) forEachSubtree(
+	ctx context.Context,
+	workers int,
+	work func(ctx context.Context, i int, n nodeI) bool,
+) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var wctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	var ents = h.root.entries()
+	var next = make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(ents); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if !work(wctx, i, ents[i].node) {
+					cancel()
+				}
+			}
+		}()
+	}
+
+Feed:
+	for i := range ents {
+		select {
+		case next <- i:
+		case <-wctx.Done():
+			break Feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// ParallelRange executes the given function for every KeyVal pair in the Hamt
+// using up to workers goroutines, each working on its own subtrees of the root
+// table. If workers <= 0, runtime.GOMAXPROCS(0) goroutines are used.
+//
+// fn is called concurrently, in no particular order, so it must be safe for
+// concurrent use. If fn returns false, or ctx is cancelled, the workers stop
+// early. ParallelRange returns ctx.Err().
+func (h *hamtBase) ParallelRange(
+	ctx context.Context,
+	workers int,
+	fn func(KeyI, interface{}) bool,
+) error {
+	return h.forEachSubtree(ctx, workers,
+		func(ctx context.Context, _ int, n nodeI) bool {
+			return rangeNode(ctx, n, fn)
+		})
+}
+
+// ParallelFold folds every KeyVal pair of the Hamt into a single value, using
+// up to runtime.GOMAXPROCS(0) goroutines. Each subtree of the root table is
+// folded, in Range() order, starting from zero; then the results are combined,
+// in root index order, starting from zero. fold must not modify zero, since
+// every subtree starts from it.
+//
+// If ctx is cancelled, the workers stop early and ParallelFold returns nil and
+// ctx.Err().
+func (h *hamtBase) ParallelFold(
+	ctx context.Context,
+	zero interface{},
+	fold func(acc interface{}, key KeyI, val interface{}) interface{},
+	combine func(acc, sub interface{}) interface{},
+) (interface{}, error) {
+	var results = make([]interface{}, h.root.nentries())
+
+	var err = h.forEachSubtree(ctx, 0,
+		func(ctx context.Context, i int, n nodeI) bool {
+			var acc = zero
+			var done = rangeNode(ctx, n, func(k KeyI, v interface{}) bool {
+				acc = fold(acc, k, v)
+				return true
+			})
+			results[i] = acc
+			return done
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var acc = zero
+	for _, r := range results {
+		acc = combine(acc, r)
+	}
+
+	return acc, nil
+}
+
+// parallelApply implements HamtTransient.ParallelApply().
+func (h *hamtBase) parallelApply(batch []BatchOp) {
+	var parts [IndexLimit][]BatchOp
+	for _, op := range batch {
+		var idx = op.Key.Hash().Index(0)
+		parts[idx] = append(parts[idx], op)
+	}
+
+	// The shards modify the subtrees in place, so the number of entries in
+	// each one must be recorded before the ops are applied.
+	var shards [IndexLimit]*HamtTransient
+	var before [IndexLimit]uint
+	var wg sync.WaitGroup
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		if len(parts[idx]) == 0 {
+			continue
+		}
+
+		// The shard starts with zeroed Stats and collisionSizes, so when
+		// the ops are done they hold the change to add to h. A count that
+		// goes "negative" wraps around, and wraps back when it is added.
+		var shard = new(HamtTransient)
+		shard.nograde = h.nograde
+		shard.startFixed = h.startFixed
+		shard.keyless = h.keyless
+		shard.collisionHook = h.collisionHook
+		shard.collisionThreshold = h.collisionThreshold
+		if node := h.root.get(idx); node != nil {
+			shard.root.insert(idx, node)
+			shard.root.nkvs = nodeCount(node)
+			shard.nentries = shard.root.nkvs
+		}
+		before[idx] = shard.nentries
+		shards[idx] = shard
+
+		wg.Add(1)
+		go func(shard *HamtTransient, ops []BatchOp) {
+			defer wg.Done()
+			for _, op := range ops {
+				if op.Del {
+					shard.Del(op.Key)
+				} else {
+					shard.Put(op.Key, op.Val)
+				}
+			}
+		}(shard, parts[idx])
+	}
+	wg.Wait()
+
+	for idx, shard := range shards {
+		if shard == nil {
+			continue
+		}
+
+		var after = shard.root.get(uint(idx))
+		switch {
+		case h.root.get(uint(idx)) == nil && after != nil:
+			h.root.insert(uint(idx), after)
+		case after == nil && h.root.get(uint(idx)) != nil:
+			h.root.remove(uint(idx))
+		case after != nil:
+			h.root.replace(uint(idx), after)
+		}
+
+		h.nentries = h.nentries - before[idx] + shard.nentries
+		h.root.nkvs = h.nentries
+		h.stats.add(&shard.stats)
+		h.addCollisionSizes(shard.collisionSizes)
+	}
+
+	h.mods++
+}
+
+// addCollisionSizes adds the counts of sizes, which may have "negative"
+// (wrapped around) counts, into h.collisionSizes.
+func (h *hamtBase) addCollisionSizes(sizes map[uint]uint) {
+	if len(sizes) == 0 {
+		return
+	}
+
+	var m = make(map[uint]uint, len(h.collisionSizes)+len(sizes))
+	for k, v := range h.collisionSizes {
+		m[k] = v
+	}
+	for k, v := range sizes {
+		m[k] += v
+		if m[k] == 0 {
+			delete(m, k)
+		}
+	}
+
+	h.collisionSizes = m
+}
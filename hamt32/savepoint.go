@@ -0,0 +1,104 @@
+package hamt32
+
+// Savepoint marks a state of a HamtTransient that it can be rolled back to;
+// see HamtTransient.Savepoint().
+type Savepoint struct {
+	// log is the undoLog the Savepoint was made in. A HamtTransient gets a
+	// new undoLog whenever it has no active Savepoint left, so a Savepoint
+	// of an old undoLog, or of another HamtTransient, is never active.
+	log *undoLog
+	id  uint64
+}
+
+// undoLog records how to undo the modifications made to a HamtTransient since
+// its oldest active Savepoint. It only exists while a Savepoint is active, so
+// a HamtTransient without one pays a single pointer comparison per Put() or
+// Del().
+type undoLog struct {
+	entries []undoEntry
+	saves   []savepoint // active Savepoints, oldest first
+	lastId  uint64
+}
+
+// undoEntry restores key to val if it existed, or deletes it if not.
+type undoEntry struct {
+	key     KeyI
+	val     interface{}
+	existed bool
+}
+
+type savepoint struct {
+	id   uint64
+	mark int // len(entries) when the Savepoint was made
+}
+
+func (u *undoLog) record(key KeyI, val interface{}, existed bool) {
+	u.entries = append(u.entries, undoEntry{key, val, existed})
+}
+
+// find returns the index of the Savepoint in u.saves; it panics if the
+// Savepoint is no longer active.
+func (u *undoLog) find(sp Savepoint, op string) int {
+	if u != nil && sp.log == u {
+		for i := len(u.saves) - 1; i >= 0; i-- {
+			if u.saves[i].id == sp.id {
+				return i
+			}
+		}
+	}
+	panic(op + ": Savepoint is not active")
+}
+
+// savepoint implements HamtTransient.Savepoint().
+func (h *hamtBase) savepoint() Savepoint {
+	if h.undo == nil {
+		h.undo = new(undoLog)
+	}
+	var u = h.undo
+	u.lastId++
+	u.saves = append(u.saves, savepoint{u.lastId, len(u.entries)})
+	return Savepoint{u, u.lastId}
+}
+
+// release implements HamtTransient.Release().
+func (h *hamtBase) release(sp Savepoint) {
+	var u = h.undo
+	var i = u.find(sp, "HamtTransient.Release")
+
+	// The entries recorded since sp now belong to the Savepoint before it.
+	u.saves = u.saves[:i]
+	if len(u.saves) == 0 {
+		u.entries = nil // let the keys and values go
+		h.undo = nil
+	}
+}
+
+// rollback implements HamtTransient.Rollback() by undoing the entries since
+// sp, newest first, with put and del. It must not record them again, so the
+// undoLog is detached while it works.
+func (h *hamtBase) rollback(
+	sp Savepoint,
+	put func(KeyI, interface{}),
+	del func(KeyI),
+) {
+	var u = h.undo
+	var i = u.find(sp, "HamtTransient.Rollback")
+	var mark = u.saves[i].mark
+
+	h.undo = nil
+	for j := len(u.entries) - 1; j >= mark; j-- {
+		var e = u.entries[j]
+		if e.existed {
+			put(e.key, e.val)
+		} else {
+			del(e.key)
+		}
+		u.entries[j] = undoEntry{} // let the keys and values go
+	}
+	u.entries = u.entries[:mark]
+	u.saves = u.saves[:i]
+
+	if len(u.saves) > 0 {
+		h.undo = u
+	}
+}
@@ -0,0 +1,141 @@
+package hamt32
+
+import (
+	"fmt"
+	"strings"
+)
+
+// setLeaf and setCollisionLeaf are the keyless leaf variants of flatLeaf and
+// collisionLeaf. They are used by a Hamt built by NewSet(), where every value
+// would be nil anyway, so they do not spend a value slot per key. As far as
+// the tables are concerned they are just leafI's.
+//
+// Their get() returns a nil value, and their put() ignores the value it is
+// given.
+
+// implements nodeI
+// implements leafI
+type setLeaf struct {
+	key KeyI
+}
+
+func newSetLeaf(key KeyI) *setLeaf {
+	var sl = new(setLeaf)
+	sl.key = key
+	return sl
+}
+
+func (l *setLeaf) Hash() HashVal {
+	return l.key.Hash()
+}
+
+func (l *setLeaf) String() string {
+	return fmt.Sprintf("setLeaf{key: %s}", l.key)
+}
+
+func (l *setLeaf) get(key KeyI) (interface{}, bool) {
+	if l.key.Equals(key) {
+		return nil, true
+	}
+	return nil, false
+}
+
+// put returns the original leaf if the key is already there; there is no
+// value to replace, so there is nothing to copy.
+func (l *setLeaf) put(key KeyI, val interface{}) (leafI, bool) {
+	if l.key.Equals(key) {
+		return l, false //replaced
+	}
+	return newSetCollisionLeaf([]KeyI{l.key, key}), true //added
+}
+
+func (l *setLeaf) del(key KeyI) (leafI, interface{}, bool) {
+	if l.key.Equals(key) {
+		return nil, nil, true //found
+	}
+	return l, nil, false //not found
+}
+
+func (l *setLeaf) keyVals() []KeyVal {
+	return []KeyVal{{l.key, nil}}
+}
+
+func (l *setLeaf) visit(fn visitFn) bool {
+	return fn(l)
+}
+
+// implements nodeI
+// implements leafI
+type setCollisionLeaf struct {
+	keys []KeyI
+}
+
+func newSetCollisionLeaf(keys []KeyI) *setCollisionLeaf {
+	var leaf = new(setCollisionLeaf)
+	leaf.keys = append(leaf.keys, keys...)
+	return leaf
+}
+
+func (l *setCollisionLeaf) Hash() HashVal {
+	return l.keys[0].Hash()
+}
+
+func (l *setCollisionLeaf) String() string {
+	var keystrs = make([]string, len(l.keys))
+	for i, key := range l.keys {
+		keystrs[i] = fmt.Sprint(key)
+	}
+
+	return fmt.Sprintf("setCollisionLeaf{hash:%s, keys:[]KeyI{%s}}",
+		l.Hash(), strings.Join(keystrs, ","))
+}
+
+func (l *setCollisionLeaf) get(key KeyI) (interface{}, bool) {
+	for _, k := range l.keys {
+		if k.Equals(key) {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func (l *setCollisionLeaf) put(key KeyI, val interface{}) (leafI, bool) {
+	for _, k := range l.keys {
+		if k.Equals(key) {
+			return l, false //replaced
+		}
+	}
+	var nl = new(setCollisionLeaf)
+	nl.keys = make([]KeyI, len(l.keys)+1)
+	copy(nl.keys, l.keys)
+	nl.keys[len(l.keys)] = key
+	return nl, true //added
+}
+
+func (l *setCollisionLeaf) del(key KeyI) (leafI, interface{}, bool) {
+	for i, k := range l.keys {
+		if k.Equals(key) {
+			if len(l.keys) == 2 {
+				return newSetLeaf(l.keys[1-i]), nil, true
+			}
+			var nl = new(setCollisionLeaf)
+			nl.keys = make([]KeyI, 0, len(l.keys)-1)
+			nl.keys = append(nl.keys, l.keys[:i]...)
+			nl.keys = append(nl.keys, l.keys[i+1:]...)
+			return nl, nil, true
+		}
+	}
+	return l, nil, false
+}
+
+func (l *setCollisionLeaf) keyVals() []KeyVal {
+	var r = make([]KeyVal, len(l.keys))
+	for i, k := range l.keys {
+		r[i] = KeyVal{k, nil}
+	}
+	return r
+}
+
+func (l *setCollisionLeaf) visit(fn visitFn) bool {
+	return fn(l)
+}
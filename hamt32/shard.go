@@ -0,0 +1,135 @@
+package hamt32
+
+import "github.com/pkg/errors"
+
+// The root table partitions the keys of a Hamt into IndexLimit subtrees by
+// the index of their HashVal at depth 0. Split() hands out contiguous ranges
+// of root slots as shards and Join() puts them back together. Neither one
+// copies anything below the root table.
+//
+// Join() just adds up the Stats of the shards, but Split() has no way to
+// divide the Stats of the original; it counts them for each shard from the
+// tables of the shard's root slots. Only the tables are visited; a leaf is
+// counted by its type, from the table slot holding it, so Split() never
+// touches a flatLeaf.
+
+// split implements Split() for both the HamtFunctional and the HamtTransient;
+// newShard returns a new, empty, Hamt of the right variety along with its
+// hamtBase.
+func (h *hamtBase) split(n uint, newShard func() (Hamt, *hamtBase)) []Hamt {
+	if n == 0 {
+		n = 1
+	}
+	if n > IndexLimit {
+		n = IndexLimit
+	}
+
+	var shards = make([]Hamt, n)
+	for i := uint(0); i < n; i++ {
+		var shard, sb = newShard()
+		sb.nograde = h.nograde
+		sb.startFixed = h.startFixed
+		sb.keyless = h.keyless
+		sb.collisionHook = h.collisionHook
+		sb.collisionThreshold = h.collisionThreshold
+
+		for idx := i * IndexLimit / n; idx < (i+1)*IndexLimit/n; idx++ {
+			var node = h.root.get(idx)
+			if node == nil {
+				continue
+			}
+			sb.root.insert(idx, node)
+			sb.addTables(node)
+			sb.nentries += nodeCount(node)
+		}
+		sb.root.nkvs = sb.nentries
+
+		shards[i] = shard
+	}
+
+	return shards
+}
+
+// Join reassembles shards made by Split() into a single HamtFunctional. The
+// shards must be disjoint by root index; that is, no two shards may have a
+// subtree in the same root slot. Shards made by Split() always are, even after
+// they have been modified, as long as every key Put() into a shard hashes into
+// one of its root slots.
+//
+// Join takes the table option and collision hook of the first shard. It is
+// O(IndexLimit) per shard; the subtrees are not copied, so the shards should
+// not be modified afterwards.
+func Join(shards ...Hamt) (Hamt, error) {
+	var nh = new(HamtFunctional)
+	if len(shards) == 0 {
+		return nh, nil
+	}
+
+	var sizes map[uint]uint
+
+	for i, shard := range shards {
+		var sb = baseOf(shard)
+		if sb == nil {
+			return nil, errors.Errorf("Join: shard %d is a %T", i, shard)
+		}
+
+		if i == 0 {
+			nh.nograde = sb.nograde
+			nh.startFixed = sb.startFixed
+			nh.keyless = sb.keyless
+			nh.collisionHook = sb.collisionHook
+			nh.collisionThreshold = sb.collisionThreshold
+		}
+
+		for _, ent := range sb.root.entries() {
+			if nh.root.get(ent.idx) != nil {
+				return nil, errors.Errorf(
+					"Join: shard %d overlaps a previous shard at root index %d",
+					i, ent.idx)
+			}
+			nh.root.insert(ent.idx, ent.node)
+		}
+
+		nh.nentries += sb.nentries
+		nh.stats.add(&sb.stats)
+		for size, num := range sb.collisionSizes {
+			if sizes == nil {
+				sizes = make(map[uint]uint)
+			}
+			sizes[size] += num
+		}
+	}
+	nh.root.nkvs = nh.nentries
+	nh.collisionSizes = sizes
+
+	return nh, nil
+}
+
+// baseOf returns the hamtBase of a HamtFunctional or HamtTransient, or nil.
+func baseOf(h Hamt) *hamtBase {
+	switch x := h.(type) {
+	case *HamtFunctional:
+		return &x.hamtBase
+	case *HamtTransient:
+		return &x.hamtBase
+	}
+	return nil
+}
+
+// add adds the incremental counts of o into s. The fields QuickStats() derives
+// are left alone.
+func (s *Stats) add(o *Stats) {
+	for i := range s.TableCountsByNentries {
+		s.TableCountsByNentries[i] += o.TableCountsByNentries[i]
+	}
+	for i := range s.TableCountsByDepth {
+		s.TableCountsByDepth[i] += o.TableCountsByDepth[i]
+	}
+	s.Nodes += o.Nodes
+	s.Tables += o.Tables
+	s.Leafs += o.Leafs
+	s.FixedTables += o.FixedTables
+	s.SparseTables += o.SparseTables
+	s.FlatLeafs += o.FlatLeafs
+	s.CollisionLeafs += o.CollisionLeafs
+}
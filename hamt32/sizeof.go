@@ -1,6 +1,7 @@
 package hamt32
 
 import (
+	"fmt"
 	"unsafe"
 )
 
@@ -9,3 +10,29 @@ var SizeofFixedTable = unsafe.Sizeof(fixedTable{})
 var SizeofSparseTable = unsafe.Sizeof(sparseTable{})
 var SizeofBitmap = unsafe.Sizeof(bitmap{})
 var SizeofNodeI = unsafe.Sizeof([1]nodeI{})
+var SizeofFlatLeaf = unsafe.Sizeof(flatLeaf{})
+var SizeofCollisionLeaf = unsafe.Sizeof(collisionLeaf{})
+var SizeofSetLeaf = unsafe.Sizeof(setLeaf{})
+var SizeofSetCollisionLeaf = unsafe.Sizeof(setCollisionLeaf{})
+var SizeofKeyVal = unsafe.Sizeof(KeyVal{})
+var SizeofKeyI = unsafe.Sizeof([1]KeyI{})
+
+// sizeofNode returns the number of bytes the node itself occupies, including
+// the arrays backing its slices, but not its children, keys, or values.
+func sizeofNode(n nodeI) uintptr {
+	switch x := n.(type) {
+	case *fixedTable:
+		return SizeofFixedTable
+	case *sparseTable:
+		return SizeofSparseTable + uintptr(cap(x.nodes))*SizeofNodeI
+	case *flatLeaf:
+		return SizeofFlatLeaf
+	case *collisionLeaf:
+		return SizeofCollisionLeaf + uintptr(cap(x.kvs))*SizeofKeyVal
+	case *setLeaf:
+		return SizeofSetLeaf
+	case *setCollisionLeaf:
+		return SizeofSetCollisionLeaf + uintptr(cap(x.keys))*SizeofKeyI
+	}
+	panic(fmt.Sprintf("sizeofNode: unknown node type %T", n))
+}
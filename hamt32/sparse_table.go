@@ -9,10 +9,11 @@ import (
 // sparseTable.
 const sparseTableInitCap int = 2
 
-// New sparseTable layout size == 44
+// New sparseTable layout size == 52
 type sparseTable struct {
 	nodes    []nodeI // 24
 	depth    uint    // 8; amd64 cpu
+	nkvs     uint    // 8; amd64 cpu
 	hashPath HashVal // 8
 	nodeMap  bitmap  // 4
 }
@@ -21,6 +22,7 @@ func (t *sparseTable) copy() tableI {
 	var nt = new(sparseTable)
 	nt.hashPath = t.hashPath
 	nt.depth = t.depth
+	nt.nkvs = t.nkvs
 	nt.nodeMap = t.nodeMap
 
 	nt.nodes = make([]nodeI, len(t.nodes), cap(t.nodes))
@@ -33,6 +35,7 @@ func (t *sparseTable) deepCopy() tableI {
 	var nt = new(sparseTable)
 	nt.hashPath = t.hashPath
 	nt.depth = t.depth
+	nt.nkvs = t.nkvs
 	nt.nodeMap = t.nodeMap
 
 	nt.nodes = make([]nodeI, len(t.nodes), cap(t.nodes))
@@ -57,7 +60,7 @@ func (t *sparseTable) deepCopy() tableI {
 	return nt
 }
 
-func createSparseTable(depth uint, leaf1 leafI, leaf2 *flatLeaf) tableI {
+func createSparseTable(depth uint, leaf1 leafI, leaf2 leafI) tableI {
 	if assertOn {
 		assert(depth > 0, "createSparseTable(): depth < 1")
 		assertf(leaf1.Hash().hashPath(depth) == leaf2.Hash().hashPath(depth),
@@ -69,6 +72,7 @@ func createSparseTable(depth uint, leaf1 leafI, leaf2 *flatLeaf) tableI {
 	var retTable = new(sparseTable)
 	retTable.hashPath = leaf1.Hash().hashPath(depth)
 	retTable.depth = depth
+	retTable.nkvs = nodeCount(leaf1) + nodeCount(leaf2)
 	//retTable.nodeMap = 0
 	retTable.nodes = make([]nodeI, 0, sparseTableInitCap)
 
@@ -80,7 +84,7 @@ func createSparseTable(depth uint, leaf1 leafI, leaf2 *flatLeaf) tableI {
 	} else { //idx1 == idx2
 		var node nodeI
 		if depth == maxDepth {
-			node = newCollisionLeaf(append(leaf1.keyVals(), leaf2.keyVals()...))
+			node = mergeLeafs(leaf1, leaf2)
 		} else {
 			node = createSparseTable(depth+1, leaf1, leaf2)
 		}
@@ -103,6 +107,8 @@ func downgradeToSparseTable(
 ) *sparseTable {
 	var nt = new(sparseTable)
 	nt.hashPath = hashPath
+	nt.depth = depth
+	nt.nkvs = countEntries(ents)
 	//nt.nodeMap = 0
 	nt.nodes = make([]nodeI, len(ents), len(ents)+1)
 
@@ -160,6 +166,14 @@ func (t *sparseTable) nentries() uint {
 	//return t.nodeMap.Count(IndexLimit)
 }
 
+func (t *sparseTable) count() uint {
+	return t.nkvs
+}
+
+func (t *sparseTable) setCount(n uint) {
+	t.nkvs = n
+}
+
 func (t *sparseTable) entries() []tableEntry {
 	var n = t.nentries()
 	var ents = make([]tableEntry, n)
@@ -0,0 +1,197 @@
+package hamt32
+
+// The hamtBase carries a Stats struct, by value, that is kept up to date by
+// Put() and Del() of both the transient and functional code. Because it is a
+// value, the `*nh = *h` copy done by the functional Put() and Del() carries
+// the counts over to the new HamtFunctional for free.
+//
+// The root table is never counted in the incremental Stats. It always exists
+// and its nentries changes with nearly every operation, so QuickStats() just
+// adds it in when it is called. For the same reason, the fields that can be
+// derived from the others (Nils, MaxDepth, and KeyVals) are only filled in by
+// QuickStats().
+//
+// MaxCollisionLeafSize can not be maintained as a simple count, because the
+// largest collisionLeaf may shrink. Instead hamtBase keeps the collisionSizes
+// map of collisionLeaf size to the number of collisionLeafs of that size.
+
+// addNode counts the given node, but none of its children, into the Stats.
+func (s *Stats) addNode(n nodeI) {
+	switch x := n.(type) {
+	case *fixedTable:
+		if x.depth == 0 {
+			return //root
+		}
+		s.FixedTables++
+		s.addTable(x.depth, x.nentries())
+	case *sparseTable:
+		s.SparseTables++
+		s.addTable(x.depth, x.nentries())
+	case *flatLeaf, *setLeaf:
+		s.Nodes++
+		s.Leafs++
+		s.FlatLeafs++
+	case *collisionLeaf, *setCollisionLeaf:
+		s.Nodes++
+		s.Leafs++
+		s.CollisionLeafs++
+	}
+}
+
+func (s *Stats) addTable(depth, nents uint) {
+	s.Nodes++
+	s.Tables++
+	s.TableCountsByNentries[nents]++
+	s.TableCountsByDepth[depth]++
+}
+
+// removeNode un-counts the given node, but none of its children, from the
+// Stats. The node must be in the same state it was in when addNode() was
+// called on it; so, for tables, call removeNode() before insert() or remove()
+// and addNode() afterwards.
+func (s *Stats) removeNode(n nodeI) {
+	switch x := n.(type) {
+	case *fixedTable:
+		if x.depth == 0 {
+			return //root
+		}
+		s.FixedTables--
+		s.removeTable(x.depth, x.nentries())
+	case *sparseTable:
+		s.SparseTables--
+		s.removeTable(x.depth, x.nentries())
+	case *flatLeaf, *setLeaf:
+		s.Nodes--
+		s.Leafs--
+		s.FlatLeafs--
+	case *collisionLeaf, *setCollisionLeaf:
+		s.Nodes--
+		s.Leafs--
+		s.CollisionLeafs--
+	}
+}
+
+func (s *Stats) removeTable(depth, nents uint) {
+	s.Nodes--
+	s.Tables--
+	s.TableCountsByNentries[nents]--
+	s.TableCountsByDepth[depth]--
+}
+
+// addNode counts the given node into h.stats and, if it is a collisionLeaf,
+// into h.collisionSizes.
+func (h *hamtBase) addNode(n nodeI) {
+	h.stats.addNode(n)
+	if size, isCollision := collisionSize(n); isCollision {
+		h.countCollision(size, true)
+	}
+}
+
+// removeNode un-counts the given node from h.stats and, if it is a
+// collisionLeaf, from h.collisionSizes.
+func (h *hamtBase) removeNode(n nodeI) {
+	h.stats.removeNode(n)
+	if size, isCollision := collisionSize(n); isCollision {
+		h.countCollision(size, false)
+	}
+}
+
+// addTree counts the given node and all of its children.
+func (h *hamtBase) addTree(n nodeI) {
+	n.visit(func(n nodeI) bool {
+		if n == nil {
+			return false
+		}
+		h.addNode(n)
+		return true
+	})
+}
+
+// addTables counts the given node and all of its children, just as addTree()
+// does, but without visiting the leafs; addNode() only needs the type of a
+// leaf, and the size of a collisionLeaf.
+func (h *hamtBase) addTables(n nodeI) {
+	h.addNode(n)
+	if t, isTable := n.(tableI); isTable {
+		for _, ent := range t.entries() {
+			h.addTables(ent.node)
+		}
+	}
+}
+
+// removeTree un-counts the given node and all of its children.
+func (h *hamtBase) removeTree(n nodeI) {
+	n.visit(func(n nodeI) bool {
+		if n == nil {
+			return false
+		}
+		h.removeNode(n)
+		return true
+	})
+}
+
+// replaceTree re-counts the subtree rooted at oldNode as the one rooted at
+// newNode; either may be nil. It only descends where the two differ, so
+// replacing a subtree with a modified version of itself is cheap.
+func (h *hamtBase) replaceTree(oldNode, newNode nodeI) {
+	if oldNode == newNode {
+		return
+	}
+
+	var ot, oldIsTable = oldNode.(tableI)
+	var nt, newIsTable = newNode.(tableI)
+	if oldIsTable && newIsTable {
+		h.removeNode(ot)
+		h.addNode(nt)
+		for idx := uint(0); idx < IndexLimit; idx++ {
+			h.replaceTree(ot.get(idx), nt.get(idx))
+		}
+		return
+	}
+
+	if oldNode != nil {
+		h.removeTree(oldNode)
+	}
+	if newNode != nil {
+		h.addTree(newNode)
+	}
+}
+
+// QuickStats returns a Stats data structure equivalent to the one returned by
+// Stats(), but without walking the Hamt. The counts are maintained as the Hamt
+// is modified, so the cost of QuickStats does not depend on the size of the
+// Hamt.
+func (h *hamtBase) QuickStats() *Stats {
+	var stats = new(Stats)
+	*stats = h.stats
+	stats.addRoot(h.root.nentries(), h.nentries)
+
+	for size := range h.collisionSizes {
+		if size > stats.MaxCollisionLeafSize {
+			stats.MaxCollisionLeafSize = size
+		}
+	}
+
+	return stats
+}
+
+// addRoot counts the root table, with the given nentries, into Stats which
+// count every other node, and fills in the fields derived from the counts.
+func (s *Stats) addRoot(rootNentries, keyVals uint) {
+	s.Nodes++
+	s.Tables++
+	s.FixedTables++
+	s.TableCountsByNentries[rootNentries]++
+	s.TableCountsByDepth[0]++
+
+	for d := maxDepth; d > 0; d-- {
+		if s.TableCountsByDepth[d] > 0 {
+			s.MaxDepth = d
+			break
+		}
+	}
+
+	// Every node but the root occupies one slot of some table.
+	s.Nils = s.Tables*IndexLimit - (s.Nodes - 1)
+	s.KeyVals = keyVals
+}
@@ -0,0 +1,358 @@
+package hamt32
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Version is one HamtFunctional recorded by a VersionStore. IDs start at 1
+// and every Commit() gets the next one; Times never decrease.
+type Version struct {
+	ID   uint64
+	Time time.Time
+	Tags []string
+	Hamt Hamt
+}
+
+// HasTag returns true if the Version was committed with the tag.
+func (v *Version) HasTag(tag string) bool {
+	for _, t := range v.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionPolicy says which Versions a VersionStore drops. A Version is
+// dropped if it is older than the MaxVersions newest ones, or if it was
+// committed more than MaxAge ago; a zero value disables that limit. If
+// KeepTagged is set, Versions with tags are never dropped. The newest Version
+// is never dropped.
+type RetentionPolicy struct {
+	MaxVersions int
+	MaxAge      time.Duration
+	KeepTagged  bool
+}
+
+// VersionStore records a history of HamtFunctionals for auditing. It answers
+// what the Hamt was, and so what a key's value was, at any time or Version
+// still retained. Versions share most of their tables with each other, so
+// keeping many of them is cheaper than it looks; MemoryReport() says how much
+// each one actually costs.
+//
+// It also keeps a cursor, which Undo() and Redo() move between the retained
+// Versions, and Commit() moves to the new Version. Undoing does not drop any
+// Versions; committing after an Undo() just makes a newer one.
+//
+// A VersionStore is safe for concurrent use.
+type VersionStore struct {
+	lock     sync.RWMutex
+	versions []*Version // retained Versions by ascending ID
+	lastId   uint64
+	cursor   int // index in versions
+	policy   RetentionPolicy
+}
+
+// NewVersionStore constructs a new, empty, VersionStore with the given
+// RetentionPolicy.
+func NewVersionStore(policy RetentionPolicy) *VersionStore {
+	var s = new(VersionStore)
+	s.policy = policy
+	return s
+}
+
+// Commit records the Hamt as a new Version, with the given tags, and moves
+// the cursor to it. If the Hamt is a HamtTransient it is converted with
+// ToFunctional(), so it must no longer be modified. Then it drops the
+// Versions the RetentionPolicy no longer retains.
+func (s *VersionStore) Commit(h Hamt, tags ...string) *Version {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var now = time.Now()
+	if n := len(s.versions); n > 0 && now.Before(s.versions[n-1].Time) {
+		now = s.versions[n-1].Time // the wall clock went backwards
+	}
+
+	s.lastId++
+	var v = &Version{
+		ID:   s.lastId,
+		Time: now,
+		Tags: append([]string(nil), tags...),
+		Hamt: h.ToFunctional(),
+	}
+	s.versions = append(s.versions, v)
+	s.cursor = len(s.versions) - 1
+
+	s.prune(now)
+	return v
+}
+
+// SetRetention replaces the RetentionPolicy and drops the Versions it no
+// longer retains.
+func (s *VersionStore) SetRetention(policy RetentionPolicy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.policy = policy
+	s.prune(time.Now())
+}
+
+// prune drops the Versions the RetentionPolicy does not retain as of now. If
+// the cursor's Version is dropped, the cursor moves to the next newer one.
+func (s *VersionStore) prune(now time.Time) {
+	var p = s.policy
+	var n = len(s.versions)
+	var kept = s.versions[:0]
+	var cursor = s.cursor
+	for i, v := range s.versions {
+		var drop = i < n-1 &&
+			((p.MaxVersions > 0 && i < n-p.MaxVersions) ||
+				(p.MaxAge > 0 && now.Sub(v.Time) > p.MaxAge)) &&
+			!(p.KeepTagged && len(v.Tags) > 0)
+		if drop {
+			if i < s.cursor {
+				cursor--
+			}
+			continue
+		}
+		kept = append(kept, v)
+	}
+	for i := len(kept); i < n; i++ {
+		s.versions[i] = nil
+	}
+	s.versions = kept
+	if cursor >= len(kept) {
+		cursor = len(kept) - 1
+	}
+	s.cursor = cursor
+}
+
+// Versions returns the retained Versions, oldest first.
+func (s *VersionStore) Versions() []*Version {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*Version(nil), s.versions...)
+}
+
+// Latest returns the newest Version, or nil if nothing was committed.
+func (s *VersionStore) Latest() *Version {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.versions) == 0 {
+		return nil
+	}
+	return s.versions[len(s.versions)-1]
+}
+
+// exact checks that versions[i] is the Version that was current right up to
+// the next retained one; that is, that the Version after it was not dropped.
+func (s *VersionStore) exact(i int) (*Version, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	var v = s.versions[i]
+	if i+1 < len(s.versions) && s.versions[i+1].ID != v.ID+1 {
+		return nil, false
+	}
+	return v, true
+}
+
+// AsOf returns the Version that was the newest at time t, and true. It
+// returns nil and false if t is before the first Version or that Version is no
+// longer retained.
+func (s *VersionStore) AsOf(t time.Time) (*Version, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var i = sort.Search(len(s.versions), func(i int) bool {
+		return s.versions[i].Time.After(t)
+	})
+	return s.exact(i - 1)
+}
+
+// AsOfID returns the Version with the given ID, and true. It returns nil and
+// false if there is no such Version or it is no longer retained.
+func (s *VersionStore) AsOfID(id uint64) (*Version, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var i = sort.Search(len(s.versions), func(i int) bool {
+		return s.versions[i].ID >= id
+	})
+	if i == len(s.versions) || s.versions[i].ID != id {
+		return nil, false
+	}
+	return s.versions[i], true
+}
+
+// Get retrieves the value the key had at time asOf. It returns the value and
+// true if the key was found, or nil and false if not. It also returns false
+// if the Version current at time asOf is not retained.
+func (s *VersionStore) Get(key KeyI, asOf time.Time) (interface{}, bool) {
+	var v, found = s.AsOf(asOf)
+	if !found {
+		return nil, false
+	}
+	return v.Hamt.Get(key)
+}
+
+// Tagged returns the newest retained Version with the tag, and true; or nil
+// and false if there is none.
+func (s *VersionStore) Tagged(tag string) (*Version, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for i := len(s.versions) - 1; i >= 0; i-- {
+		if s.versions[i].HasTag(tag) {
+			return s.versions[i], true
+		}
+	}
+	return nil, false
+}
+
+// Current returns the Version at the cursor, or nil if nothing was committed.
+func (s *VersionStore) Current() *Version {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.versions) == 0 {
+		return nil
+	}
+	return s.versions[s.cursor]
+}
+
+// Undo moves the cursor to the previous retained Version and returns it and
+// true; or returns the Version at the cursor and false if there is none.
+func (s *VersionStore) Undo() (*Version, bool) {
+	return s.move(-1)
+}
+
+// Redo moves the cursor to the next retained Version and returns it and true;
+// or returns the Version at the cursor and false if there is none.
+func (s *VersionStore) Redo() (*Version, bool) {
+	return s.move(1)
+}
+
+func (s *VersionStore) move(delta int) (*Version, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.versions) == 0 {
+		return nil, false
+	}
+	var i = s.cursor + delta
+	if i < 0 || i >= len(s.versions) {
+		return s.versions[s.cursor], false
+	}
+	s.cursor = i
+	return s.versions[i], true
+}
+
+// VersionCost is the memory a retained Version occupies, by MemoryReport().
+// Only the tables and leafs are counted, not the keys and values.
+//
+// Bytes is the size of the whole Version, as if it shared nothing. Added is
+// what it added to the Versions before it; the Added of all the Versions sum
+// to the total. Unique is what only it uses, so what dropping it would free.
+type VersionCost struct {
+	ID     uint64
+	Bytes  uintptr
+	Added  uintptr
+	Unique uintptr
+}
+
+// nodeUse is what MemoryReport() knows about one node.
+type nodeUse struct {
+	first   int     // the index of the first Version that uses it
+	shared  bool    // used by more than one Version
+	subtree uintptr // the size of it and its descendants
+}
+
+// MemoryReport returns the VersionCost of each retained Version, oldest
+// first, and the total memory all of them occupy together. It finds the
+// tables and leafs the Versions share by walking each of them once, but not
+// the subtrees it already walked for an earlier Version.
+//
+// A Version of a Hamt other than a HamtFunctional or a HamtTransient, such as
+// a CtrieSnapshot, is measured through the NodeViews of its Root(); its root
+// table is counted, but not whatever else it is built of, like iNodes. A
+// Version of a Hamt implemented outside of this package can not be measured;
+// its VersionCost has only its ID.
+func (s *VersionStore) MemoryReport() ([]VersionCost, uintptr) {
+	var versions = s.Versions()
+
+	var uses = make(map[nodeI]*nodeUse)
+
+	// markShared marks the subtree rooted at v as shared. Each node is only
+	// marked once, so this costs O(nodes) overall.
+	var markShared func(v nodeView)
+	markShared = func(v nodeView) {
+		var u = uses[v.node]
+		if u.shared {
+			return
+		}
+		u.shared = true
+		for _, c := range v.children() {
+			markShared(c)
+		}
+	}
+
+	var use func(v nodeView, i int) uintptr
+	use = func(v nodeView, i int) uintptr {
+		if u, seen := uses[v.node]; seen {
+			markShared(v)
+			return u.subtree
+		}
+		var u = &nodeUse{first: i}
+		uses[v.node] = u
+		var size = sizeofNode(v.node)
+		for _, c := range v.children() {
+			size += use(c, i)
+		}
+		u.subtree = size
+		return size
+	}
+
+	// Every Version has its own root table, inside its hamtBase.
+	var costs = make([]VersionCost, len(versions))
+	var total uintptr
+	for i, v := range versions {
+		costs[i].ID = v.ID
+		var root, isView = v.Hamt.Root().(nodeView)
+		if !isView {
+			continue
+		}
+		var own = SizeofHamtBase
+		if baseOf(v.Hamt) == nil {
+			own = 0
+			if root.node != nil {
+				own = sizeofNode(root.node)
+			}
+		}
+
+		var size = own
+		for _, c := range root.children() {
+			size += use(c, i)
+		}
+		costs[i] = VersionCost{
+			ID:     v.ID,
+			Bytes:  size,
+			Added:  own,
+			Unique: own,
+		}
+		total += own
+	}
+
+	for n, u := range uses {
+		var size = sizeofNode(n)
+		costs[u.first].Added += size
+		if !u.shared {
+			costs[u.first].Unique += size
+		}
+		total += size
+	}
+
+	return costs, total
+}
@@ -0,0 +1,164 @@
+package hamt64
+
+// A Builder constructs a HamtFunctional from a large number of KeyVal pairs
+// bottom-up, rather than by a Put() per pair. All the keys are hashed up front
+// and partitioned, a level at a time, by the index of their HashVal; then each
+// table is made once at its final size. So there is no find(), no path copying,
+// and no sparseTable to fixedTable upgrades along the way.
+//
+// The result has exactly the shape the same keys would have produced by
+// Put()ing them into an empty HamtFunctional with the same table option.
+
+type hashedKeyVal struct {
+	hv HashVal
+	kv KeyVal
+}
+
+// Builder accumulates KeyVal pairs for Build().
+type Builder struct {
+	tblOpt int
+	ents   []hashedKeyVal
+}
+
+// NewBuilder constructs a new, empty, Builder for a HamtFunctional with the
+// given table option.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewBuilder(tblOpt int) *Builder {
+	var b = new(Builder)
+	b.tblOpt = tblOpt
+	return b
+}
+
+// Add queues a (key,value) pair for Build(). If the same key is added more
+// than once, the last value added wins; just like Put().
+func (b *Builder) Add(key KeyI, val interface{}) {
+	b.ents = append(b.ents, hashedKeyVal{key.Hash(), KeyVal{key, val}})
+}
+
+// Len returns the number of (key,value) pairs added to the Builder, including
+// any duplicate keys.
+func (b *Builder) Len() int {
+	return len(b.ents)
+}
+
+// Build constructs a new HamtFunctional from every (key,value) pair added to
+// the Builder. The Builder may be added to and built again afterwards.
+func (b *Builder) Build() *HamtFunctional {
+	var h = NewFunctional(b.tblOpt)
+	if len(b.ents) == 0 {
+		return h
+	}
+
+	var scratch = make([]hashedKeyVal, len(b.ents))
+	var offs = partition(0, b.ents, scratch)
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var lo, hi = offs[idx], offs[idx+1]
+		if lo == hi {
+			continue
+		}
+		var node = h.buildNode(1, b.ents[lo:hi], scratch[lo:hi])
+		h.root.insert(idx, node)
+		h.addTree(node)
+		h.nentries += nodeCount(node)
+	}
+	h.root.nkvs = h.nentries
+
+	return h
+}
+
+// FromSlice constructs a new HamtFunctional, with the given table option, from
+// a slice of KeyVal pairs using a Builder.
+func FromSlice(kvs []KeyVal, tblOpt int) *HamtFunctional {
+	var b = NewBuilder(tblOpt)
+	b.ents = make([]hashedKeyVal, 0, len(kvs))
+	for _, kv := range kvs {
+		b.Add(kv.Key, kv.Val)
+	}
+	return b.Build()
+}
+
+// FromIter constructs a new HamtFunctional, with the given table option, from
+// the (key,value) pairs returned by next using a Builder. next is called until
+// it returns false.
+func FromIter(
+	next func() (KeyI, interface{}, bool),
+	tblOpt int,
+) *HamtFunctional {
+	var b = NewBuilder(tblOpt)
+	for {
+		var key, val, ok = next()
+		if !ok {
+			break
+		}
+		b.Add(key, val)
+	}
+	return b.Build()
+}
+
+// partition stably sorts ents by the index of their HashVal at depth, using
+// scratch (which must be the same length as ents) as a buffer. It returns the
+// offsets of each index's entries; index i's are ents[offs[i]:offs[i+1]].
+func partition(
+	depth uint,
+	ents, scratch []hashedKeyVal,
+) (offs [IndexLimit + 1]int) {
+	for _, ent := range ents {
+		offs[ent.hv.Index(depth)+1]++
+	}
+	for i := 1; i <= IndexLimit; i++ {
+		offs[i] += offs[i-1]
+	}
+
+	var next = offs
+	for _, ent := range ents {
+		var idx = ent.hv.Index(depth)
+		scratch[next[idx]] = ent
+		next[idx]++
+	}
+	copy(ents, scratch)
+
+	return offs
+}
+
+// buildNode returns the node for the slot, at depth-1, holding ents. That is
+// a leaf if every entry has the same HashVal, or if there are no more levels;
+// otherwise it is a table at depth.
+func (h *hamtBase) buildNode(depth uint, ents, scratch []hashedKeyVal) nodeI {
+	var sameHash = true
+	for _, ent := range ents[1:] {
+		if ent.hv != ents[0].hv {
+			sameHash = false
+			break
+		}
+	}
+
+	if sameHash || depth > maxDepth {
+		var leaf = h.newLeaf(ents[0].kv.Key, ents[0].kv.Val)
+		for _, ent := range ents[1:] {
+			leaf, _ = leaf.put(ent.kv.Key, ent.kv.Val)
+		}
+		return leaf
+	}
+
+	var offs = partition(depth, ents, scratch)
+	var tents = make([]tableEntry, 0, IndexLimit)
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var lo, hi = offs[idx], offs[idx+1]
+		if lo == hi {
+			continue
+		}
+		tents = append(tents, tableEntry{idx,
+			h.buildNode(depth+1, ents[lo:hi], scratch[lo:hi])})
+	}
+
+	// Put() upgrades a sparseTable when it grows to UpgradeThreshold entries
+	// and never downgrades one, so a HybridTables table of at least that
+	// size is a fixedTable.
+	var hashPath = ents[0].hv.hashPath(depth)
+	if h.startFixed || (!h.nograde && uint(len(tents)) >= UpgradeThreshold) {
+		return upgradeToFixedTable(hashPath, depth, tents)
+	}
+	return downgradeToSparseTable(hashPath, depth, tents)
+}
@@ -0,0 +1,73 @@
+package hamt64
+
+// CollisionHook is the type of the function a Hamt calls when a collisionLeaf
+// is created or grows past the collision threshold. The hv argument is the
+// HashVal shared by every key in the collisionLeaf and the size argument is
+// the number of KeyVal pairs the collisionLeaf now holds.
+//
+// Honest collisions are rare and almost always just two keys, so a
+// CollisionHook being called repeatedly for the same HashVal is a good sign
+// someone is deliberately feeding the Hamt colliding keys (aka hash flooding).
+//
+// The hook is called synchronously from within Put(); it should be fast and
+// must not modify the Hamt.
+type CollisionHook func(hv HashVal, size uint)
+
+func (h *hamtBase) setCollisionHook(threshold uint, hook CollisionHook) {
+	h.collisionThreshold = threshold
+	h.collisionHook = hook
+}
+
+// collided is called by Put() with the new leaf whenever a key was added to an
+// existing leaf. If the leaf is a collisionLeaf that was just created or has
+// grown past the threshold, the collision hook is called.
+func (h *hamtBase) collided(n nodeI) {
+	if h.collisionHook == nil {
+		return
+	}
+
+	var size, isCollision = collisionSize(n)
+	if !isCollision {
+		return
+	}
+
+	if size == 2 || size > h.collisionThreshold {
+		h.collisionHook(n.Hash(), size)
+	}
+}
+
+// collisionSize returns the number of keys in a collisionLeaf or a
+// setCollisionLeaf and true, or 0 and false for any other node.
+func collisionSize(n nodeI) (uint, bool) {
+	switch x := n.(type) {
+	case *collisionLeaf:
+		return uint(len(x.kvs)), true
+	case *setCollisionLeaf:
+		return uint(len(x.keys)), true
+	}
+	return 0, false
+}
+
+// countCollision increments (incr == true) or decrements (incr == false) the
+// number of collisionLeafs of the given size in h.collisionSizes.
+//
+// The map is copied before being modified, because it is shared between every
+// HamtFunctional version derived from the same original. Collisions are rare
+// and the map only holds the distinct sizes, so the copy is cheap.
+func (h *hamtBase) countCollision(size uint, incr bool) {
+	var m = make(map[uint]uint, len(h.collisionSizes)+1)
+	for k, v := range h.collisionSizes {
+		m[k] = v
+	}
+
+	if incr {
+		m[size]++
+	} else {
+		m[size]--
+		if m[size] == 0 {
+			delete(m, size)
+		}
+	}
+
+	h.collisionSizes = m
+}
@@ -0,0 +1,132 @@
+package hamt64
+
+// ChangeKind says how a key differs between two Hamts.
+type ChangeKind int
+
+const (
+	// Added indicates the key is only in the newer Hamt.
+	Added ChangeKind = iota
+	// Removed indicates the key is only in the older Hamt.
+	Removed
+	// Modified indicates the key is in both Hamts with different values.
+	Modified
+)
+
+// ChangeKindName maps Added, Removed, and Modified to their names.
+var ChangeKindName = [3]string{"Added", "Removed", "Modified"}
+
+// Change is one difference between two Hamts found by Diff(). Old is the
+// value in the older Hamt (nil if Added) and New is the value in the newer
+// Hamt (nil if Removed).
+type Change struct {
+	Kind ChangeKind
+	Key  KeyI
+	Old  interface{}
+	New  interface{}
+}
+
+// Diff calls fn with every Change from the Hamt from to the Hamt to, until fn
+// returns false. It returns false if fn did.
+//
+// Diff walks both Hamts at once and skips any subtree the two share, so when
+// to was derived from from by a few functional Put()s and Del()s it costs
+// about as much as those Put()s and Del()s did. Two unrelated Hamts share
+// nothing, so they are compared entry by entry.
+//
+// A key whose value was replaced by an equal value is not reported. Values of
+// types that are not comparable are never equal.
+func Diff(from, to Hamt, fn func(Change) bool) bool {
+	var a, b = baseOf(from), baseOf(to)
+	if a == nil || b == nil {
+		panic("Diff: Hamts must be a HamtFunctional or HamtTransient")
+	}
+	if a == b {
+		return true
+	}
+	return diffTables(&a.root, &b.root, fn)
+}
+
+// diffTables diffs two tables at the same depth and hashPath, slot by slot.
+func diffTables(a, b tableI, fn func(Change) bool) bool {
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		if !diffNodes(a.get(idx), b.get(idx), fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffNodes diffs two nodes from the same slot of two tables.
+func diffNodes(a, b nodeI, fn func(Change) bool) bool {
+	if a == b {
+		return true
+	}
+
+	var at, aIsTable = a.(tableI)
+	var bt, bIsTable = b.(tableI)
+	if aIsTable && bIsTable {
+		return diffTables(at, bt, fn)
+	}
+
+	// At least one side is a leaf or nil, so it holds very few KeyVals and
+	// the quadratic matching below is linear in the size of the other side.
+	var akvs, bkvs = subtreeKeyVals(a), subtreeKeyVals(b)
+
+	for _, akv := range akvs {
+		var bkv, found = findKeyVal(bkvs, akv.Key)
+		if !found {
+			if !fn(Change{Removed, akv.Key, akv.Val, nil}) {
+				return false
+			}
+		} else if !valuesEqual(akv.Val, bkv.Val) {
+			if !fn(Change{Modified, akv.Key, akv.Val, bkv.Val}) {
+				return false
+			}
+		}
+	}
+
+	for _, bkv := range bkvs {
+		if _, found := findKeyVal(akvs, bkv.Key); !found {
+			if !fn(Change{Added, bkv.Key, nil, bkv.Val}) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// subtreeKeyVals returns every KeyVal in the subtree rooted at n.
+func subtreeKeyVals(n nodeI) []KeyVal {
+	var kvs []KeyVal
+	if n == nil {
+		return kvs
+	}
+	n.visit(func(n nodeI) bool {
+		if l, isLeaf := n.(leafI); isLeaf {
+			kvs = append(kvs, l.keyVals()...)
+		}
+		return true
+	})
+	return kvs
+}
+
+func findKeyVal(kvs []KeyVal, key KeyI) (KeyVal, bool) {
+	for _, kv := range kvs {
+		if kv.Key.Equals(key) {
+			return kv, true
+		}
+	}
+	return KeyVal{}, false
+}
+
+// valuesEqual compares two values with ==, except that values which can not
+// be compared are never equal, rather than causing a panic.
+func valuesEqual(a, b interface{}) (equal bool) {
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+	return a == b
+}
@@ -9,6 +9,7 @@ type fixedTable struct {
 	nodes    [IndexLimit]nodeI
 	depth    uint
 	nents    uint
+	nkvs     uint
 	hashPath HashVal
 }
 
@@ -23,6 +24,7 @@ func (t *fixedTable) deepCopy() tableI {
 	nt.hashPath = t.hashPath
 	nt.depth = t.depth
 	nt.nents = t.nents
+	nt.nkvs = t.nkvs
 	for i := 0; i < len(t.nodes); i++ {
 		if table, isTable := t.nodes[i].(tableI); isTable {
 			nt.nodes[i] = table.deepCopy()
@@ -55,7 +57,7 @@ func (t *fixedTable) deepCopy() tableI {
 //	return ft
 //}
 
-func createFixedTable(depth uint, leaf1 leafI, leaf2 *flatLeaf) tableI {
+func createFixedTable(depth uint, leaf1 leafI, leaf2 leafI) tableI {
 	if assertOn {
 		assertf(depth > 0, "createFixedTable(): depth,%d < 1", depth)
 		assertf(leaf1.Hash().hashPath(depth) == leaf2.Hash().hashPath(depth),
@@ -67,6 +69,7 @@ func createFixedTable(depth uint, leaf1 leafI, leaf2 *flatLeaf) tableI {
 	var retTable = new(fixedTable)
 	retTable.hashPath = leaf1.Hash().hashPath(depth)
 	retTable.depth = depth
+	retTable.nkvs = nodeCount(leaf1) + nodeCount(leaf2)
 
 	var idx1 = leaf1.Hash().Index(depth)
 	var idx2 = leaf2.Hash().Index(depth)
@@ -76,7 +79,7 @@ func createFixedTable(depth uint, leaf1 leafI, leaf2 *flatLeaf) tableI {
 	} else { //idx1 == idx2
 		var node nodeI
 		if depth == maxDepth {
-			node = newCollisionLeaf(append(leaf1.keyVals(), leaf2.keyVals()...))
+			node = mergeLeafs(leaf1, leaf2)
 		} else {
 			node = createFixedTable(depth+1, leaf1, leaf2)
 		}
@@ -95,6 +98,7 @@ func upgradeToFixedTable(
 	ft.hashPath = hashPath
 	ft.depth = depth
 	ft.nents = uint(len(ents))
+	ft.nkvs = countEntries(ents)
 
 	for _, ent := range ents {
 		ft.nodes[ent.idx] = ent.node
@@ -147,6 +151,14 @@ func (t *fixedTable) nentries() uint {
 	return t.nents
 }
 
+func (t *fixedTable) count() uint {
+	return t.nkvs
+}
+
+func (t *fixedTable) setCount(n uint) {
+	t.nkvs = n
+}
+
 func (t *fixedTable) entries() []tableEntry {
 	var n = t.nentries()
 	var ents = make([]tableEntry, n)
@@ -1,5 +1,10 @@
 package hamt64
 
+import (
+	"context"
+	"math/rand"
+)
+
 // HamtFunctional is the data structure which the Funcitonal Hamt methods are
 // called upon. In fact it is identical to the HamtTransient data structure and
 // all the table and leaf data structures it uses are the same ones used by the
@@ -64,6 +69,11 @@ func (h *HamtFunctional) DeepCopy() Hamt {
 	nh.nentries = h.nentries
 	nh.nograde = h.nograde
 	nh.startFixed = h.startFixed
+	nh.stats = h.stats
+	nh.collisionSizes = h.collisionSizes
+	nh.collisionHook = h.collisionHook
+	nh.collisionThreshold = h.collisionThreshold
+	nh.keyless = h.keyless
 	return nh
 }
 
@@ -95,11 +105,16 @@ func (h *HamtFunctional) persist(oldTable, newTable tableI, path tableStack) {
 	}
 
 	if newTable == nil {
+		h.removeNode(newParent)
 		newParent.remove(parentIdx)
+		h.addNode(newParent)
 	} else {
 		newParent.replace(parentIdx, newTable)
 	}
 
+	newParent.setCount(
+		newParent.count() - oldTable.count() + nodeCount(newTable))
+
 	if path.len() > 0 {
 		h.persist(oldParent, newParent, path)
 	}
@@ -136,22 +151,36 @@ func (h *HamtFunctional) Put(key KeyI, val interface{}) (Hamt, bool) {
 	if curTable == &h.root {
 		//copying all h.root into nh.root already done in *nh = *h
 		if leaf == nil {
-			nh.root.insert(idx, newFlatLeaf(key, val))
+			var newLeaf = nh.newLeaf(key, val)
+			nh.root.insert(idx, newLeaf)
+			nh.addNode(newLeaf)
 			added = true
 		} else {
 			var node nodeI
 			if leaf.Hash() == hv {
 				node, added = leaf.put(key, val)
+				nh.addNode(node)
+				if added {
+					nh.collided(node)
+				}
 			} else {
-				node = nh.createTable(depth+1, leaf, newFlatLeaf(key, val))
+				node = nh.createTable(depth+1, leaf, nh.newLeaf(key, val))
+				nh.addTree(node)
 				added = true
 			}
+			nh.removeNode(leaf)
 
 			nh.root.replace(idx, node)
 		}
+
+		if added {
+			nh.root.nkvs++
+		}
 	} else {
 		var newTable tableI
 
+		nh.removeNode(curTable)
+
 		if leaf == nil {
 			if !nh.nograde && (curTable.nentries()+1) == UpgradeThreshold {
 				newTable = upgradeToFixedTable(
@@ -160,7 +189,9 @@ func (h *HamtFunctional) Put(key KeyI, val interface{}) (Hamt, bool) {
 				newTable = curTable.copy()
 			}
 
-			newTable.insert(idx, newFlatLeaf(key, val))
+			var newLeaf = nh.newLeaf(key, val)
+			newTable.insert(idx, newLeaf)
+			nh.addNode(newLeaf)
 			added = true
 		} else {
 			newTable = curTable.copy()
@@ -168,14 +199,26 @@ func (h *HamtFunctional) Put(key KeyI, val interface{}) (Hamt, bool) {
 			var node nodeI
 			if leaf.Hash() == hv {
 				node, added = leaf.put(key, val)
+				nh.addNode(node)
+				if added {
+					nh.collided(node)
+				}
 			} else {
-				node = nh.createTable(depth+1, leaf, newFlatLeaf(key, val))
+				node = nh.createTable(depth+1, leaf, nh.newLeaf(key, val))
+				nh.addTree(node)
 				added = true
 			}
+			nh.removeNode(leaf)
 
 			newTable.replace(idx, node)
 		}
 
+		if added {
+			incrCount(newTable, true)
+		}
+
+		nh.addNode(newTable)
+
 		nh.persist(curTable, newTable, path)
 	}
 
@@ -221,6 +264,11 @@ func (h *HamtFunctional) Del(key KeyI) (Hamt, interface{}, bool) {
 
 	nh.nentries--
 
+	nh.removeNode(leaf)
+	if newLeaf != nil {
+		nh.addNode(newLeaf)
+	}
+
 	if curTable == &h.root {
 		//copying all h.root into nh.root already done in *nh = *h
 		if newLeaf == nil { //leaf was a FlatLeaf
@@ -228,8 +276,12 @@ func (h *HamtFunctional) Del(key KeyI) (Hamt, interface{}, bool) {
 		} else { //leaf was a CollisionLeaf
 			nh.root.replace(idx, newLeaf)
 		}
+		nh.root.nkvs--
 	} else {
 		var newTable = curTable.copy()
+		incrCount(newTable, false)
+
+		nh.removeNode(curTable)
 
 		if newLeaf == nil { //leaf was a FlatLeaf
 			newTable.remove(idx)
@@ -247,6 +299,10 @@ func (h *HamtFunctional) Del(key KeyI) (Hamt, interface{}, bool) {
 			newTable.replace(idx, newLeaf)
 		}
 
+		if newTable != nil {
+			nh.addNode(newTable)
+		}
+
 		nh.persist(curTable, newTable, path)
 	}
 
@@ -273,6 +329,25 @@ func (h *HamtFunctional) walk(fn visitFn) bool {
 	return h.hamtBase.walk(fn)
 }
 
+// Root returns a read-only NodeView of the root table of the HamtFunctional.
+func (h *HamtFunctional) Root() NodeView {
+	return h.hamtBase.Root()
+}
+
+// Walk traverses the HamtFunctional calling fn with a NodeView of every table
+// and leaf (PreOrder) or of every leaf (LeafsOnly). The traversal stops, and
+// Walk returns false, if fn returns false.
+func (h *HamtFunctional) Walk(mode WalkMode, fn func(NodeView) bool) bool {
+	return h.hamtBase.Walk(mode, fn)
+}
+
+// KeySet returns a functional Set view of the keys of the HamtFunctional. It
+// shares the trie with the HamtFunctional, so it costs nothing to construct.
+// Keys added to the Set are given a nil value.
+func (h *HamtFunctional) KeySet() *Set {
+	return &Set{h}
+}
+
 // Range executes the given function for every KeyVal pair in the Hamt. KeyVal
 // pairs are visited in a seeminly random order.
 //
@@ -288,3 +363,108 @@ func (h *HamtFunctional) Range(fn func(KeyI, interface{}) bool) {
 func (h *HamtFunctional) Stats() *Stats {
 	return h.hamtBase.Stats()
 }
+
+// QuickStats returns the same Stats data structure as Stats(), but from counts
+// maintained during Put() and Del() rather than from a full walk of the Hamt.
+func (h *HamtFunctional) QuickStats() *Stats {
+	return h.hamtBase.QuickStats()
+}
+
+// SetCollisionHook returns a new HamtFunctional that calls hook whenever a
+// collisionLeaf is created, and whenever one grows to more than threshold
+// KeyVal pairs. A nil hook turns collision monitoring off. The hook is carried
+// over to every HamtFunctional derived from the new one.
+func (h *HamtFunctional) SetCollisionHook(
+	threshold uint,
+	hook CollisionHook,
+) Hamt {
+	var nh = new(HamtFunctional)
+	*nh = *h
+	nh.setCollisionHook(threshold, hook)
+	return nh
+}
+
+// Nth returns the i'th KeyVal pair of the HamtFunctional in hash order, the
+// order Range() visits them in, and true; or an empty KeyVal and false if
+// i >= Nentries().
+func (h *HamtFunctional) Nth(i uint) (KeyVal, bool) {
+	return h.hamtBase.Nth(i)
+}
+
+// Rank returns the position of the key in hash order and true, or 0 and false
+// if the key is not in the HamtFunctional.
+func (h *HamtFunctional) Rank(key KeyI) (uint, bool) {
+	return h.hamtBase.Rank(key)
+}
+
+// RandomEntry returns a KeyVal pair of the HamtFunctional chosen uniformly at
+// random and true, or an empty KeyVal and false if the HamtFunctional is empty.
+func (h *HamtFunctional) RandomEntry(src rand.Source) (KeyVal, bool) {
+	return h.hamtBase.RandomEntry(src)
+}
+
+// Sample returns n distinct KeyVal pairs of the HamtFunctional chosen uniformly
+// at random, in hash order.
+func (h *HamtFunctional) Sample(n uint, src rand.Source) []KeyVal {
+	return h.hamtBase.Sample(n, src)
+}
+
+// Seek returns a Cursor for the first leaf of the HamtFunctional whose HashVal
+// is at or after hv in hash order and true, or the zero Cursor and false if
+// there is no such leaf.
+func (h *HamtFunctional) Seek(hv HashVal) (Cursor, bool) {
+	return h.hamtBase.Seek(hv)
+}
+
+// RangeFrom executes the given function for every KeyVal pair of the
+// HamtFunctional at or after the Cursor, in Range() order. If fn returns false
+// it returns a Cursor to resume from and false; otherwise it returns the zero
+// Cursor and true.
+func (h *HamtFunctional) RangeFrom(
+	c Cursor,
+	fn func(KeyI, interface{}) bool,
+) (Cursor, bool) {
+	return h.hamtBase.RangeFrom(c, fn)
+}
+
+// Iter returns an Iterator over the HamtFunctional, positioned before the first
+// KeyVal pair. It can not Delete().
+func (h *HamtFunctional) Iter() *Iterator {
+	return h.iter(nil)
+}
+
+// Split divides the HamtFunctional into n shards, each holding the subtrees of
+// a contiguous range of root slots; n is limited to IndexLimit. The shards are
+// HamtFunctionals which share their subtrees with the original. A shard
+// converted with ToTransient() is disjoint from the other shards, so it can be
+// modified by its own goroutine, but, as with any ToTransient(), only once the
+// original is no longer used. Use Join() to put the shards back together.
+func (h *HamtFunctional) Split(n uint) []Hamt {
+	return h.split(n, func() (Hamt, *hamtBase) {
+		var nh = new(HamtFunctional)
+		return nh, &nh.hamtBase
+	})
+}
+
+// ParallelRange executes the given function for every KeyVal pair in the
+// HamtFunctional using up to workers goroutines; see hamtBase.ParallelRange. fn
+// must be safe for concurrent use. It returns ctx.Err().
+func (h *HamtFunctional) ParallelRange(
+	ctx context.Context,
+	workers int,
+	fn func(KeyI, interface{}) bool,
+) error {
+	return h.hamtBase.ParallelRange(ctx, workers, fn)
+}
+
+// ParallelFold folds every KeyVal pair in the HamtFunctional into a single
+// value, folding the subtrees of the root table concurrently and combining the
+// results in root index order.
+func (h *HamtFunctional) ParallelFold(
+	ctx context.Context,
+	zero interface{},
+	fold func(acc interface{}, key KeyI, val interface{}) interface{},
+	combine func(acc, sub interface{}) interface{},
+) (interface{}, error) {
+	return h.hamtBase.ParallelFold(ctx, zero, fold, combine)
+}
@@ -0,0 +1,90 @@
+package hamttest_test
+
+import (
+	"flag"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/lleo/go-hamt/hamt64"
+	"github.com/lleo/go-hamt/hamt64/hamttest"
+)
+
+func TestCheckKey(t *testing.T) {
+	hamttest.CheckKey(t, func(i int) hamt64.KeyI {
+		return hamt64.StringKey("key" + strconv.Itoa(i))
+	}, 1000)
+	hamttest.CheckKey(t, func(i int) hamt64.KeyI {
+		return hamt64.ByteSliceKey("key" + strconv.Itoa(i))
+	}, 1000)
+	hamttest.CheckKey(t, func(i int) hamt64.KeyI {
+		return hamt64.Int64Key(i)
+	}, 1000)
+	hamttest.CheckKey(t, func(i int) hamt64.KeyI {
+		return hamt64.Uint32Key(i)
+	}, 1000)
+	hamttest.CheckKey(t, func(i int) hamt64.KeyI {
+		return hamttest.CollidingKey{Hv: 42, Name: strconv.Itoa(i)}
+	}, 1000)
+}
+
+func TestCheckModel(t *testing.T) {
+	var keys = hamttest.StringKeys("model", 2000)
+	keys = append(keys,
+		hamttest.CollidingKeys(hamt64.CalcHash([]byte("collide")), 6)...)
+	keys = append(keys,
+		hamttest.DeepKeys(hamt64.CalcHash([]byte("deep")), 8)...)
+
+	for i, cfg := range hamttest.AllConfigs {
+		t.Run(cfg.String(), func(t *testing.T) {
+			var rnd = rand.New(rand.NewSource(int64(i)))
+			var h = hamttest.CheckModel(t, cfg.New(), keys, 50000, rnd)
+			if h.IsEmpty() {
+				t.Fatal("CheckModel left the Hamt empty; expected it not to be")
+			}
+		})
+	}
+}
+
+func TestFlags(t *testing.T) {
+	var tests = []struct {
+		args     []string
+		expected []hamttest.Config
+		err      error
+	}{
+		{nil, hamttest.AllConfigs, nil},
+		{[]string{"-A", "-b"}, hamttest.AllConfigs, nil},
+		{[]string{"-H", "-f"},
+			[]hamttest.Config{{true, hamt64.HybridTables}}, nil},
+		{[]string{"-S"}, []hamttest.Config{
+			{false, hamt64.SparseTables},
+			{true, hamt64.SparseTables}}, nil},
+		{[]string{"-t"}, hamttest.AllConfigs[:3], nil},
+		{[]string{"-F", "-S"}, nil, hamttest.ErrFlags},
+		{[]string{"-f", "-t"}, nil, hamttest.ErrFlags},
+	}
+
+	for _, test := range tests {
+		var fs = flag.NewFlagSet("TestFlags", flag.ContinueOnError)
+		var flags = hamttest.RegisterFlags(fs)
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatalf("fs.Parse(%q) => %s", test.args, err)
+		}
+
+		var configs, err = flags.Configs()
+		if err != test.err {
+			t.Fatalf("Configs() for %q err=%v; expected %v",
+				test.args, err, test.err)
+		}
+		if len(configs) != len(test.expected) {
+			t.Fatalf("Configs() for %q=%v; expected %v",
+				test.args, configs, test.expected)
+		}
+		for i := range configs {
+			if configs[i] != test.expected[i] {
+				t.Fatalf("Configs() for %q=%v; expected %v",
+					test.args, configs, test.expected)
+			}
+		}
+	}
+}
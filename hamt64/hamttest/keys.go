@@ -0,0 +1,160 @@
+package hamttest
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/lleo/go-hamt/hamt64"
+)
+
+// CollidingKey is a KeyI whose Hash() is whatever HashVal it was constructed
+// with. Keys with the same Hv and different Names collide, so they are stored
+// in a collisionLeaf.
+type CollidingKey struct {
+	Hv   hamt64.HashVal
+	Name string
+}
+
+// Hash returns k.Hv.
+func (k CollidingKey) Hash() hamt64.HashVal {
+	return k.Hv
+}
+
+// Equals returns true if K is a CollidingKey with the same Hv and Name.
+func (k CollidingKey) Equals(K hamt64.KeyI) bool {
+	var other, ok = K.(CollidingKey)
+	return ok && k == other
+}
+
+// String returns a representation of the CollidingKey.
+func (k CollidingKey) String() string {
+	return fmt.Sprintf("CollidingKey{%s, %q}", k.Hv, k.Name)
+}
+
+// CollidingKeys returns n distinct keys that all have the HashVal hv.
+func CollidingKeys(hv hamt64.HashVal, n int) []hamt64.KeyI {
+	var keys = make([]hamt64.KeyI, n)
+	for i := range keys {
+		keys[i] = CollidingKey{hv, strconv.Itoa(i)}
+	}
+	return keys
+}
+
+// DeepKeys returns n distinct keys whose HashVals differ from hv only in the
+// index value at maxDepth (DepthLimit-1). Putting two or more of them into a
+// Hamt builds a chain of tables all the way down to maxDepth. The index value
+// only has IndexLimit possible values, so DeepKeys panics if n > IndexLimit.
+func DeepKeys(hv hamt64.HashVal, n int) []hamt64.KeyI {
+	if n > hamt64.IndexLimit {
+		panic("hamttest.DeepKeys: n > IndexLimit")
+	}
+
+	const shift = (hamt64.DepthLimit - 1) * hamt64.NumIndexBits
+
+	var base = hv &^ (hamt64.HashVal(hamt64.IndexLimit-1) << shift)
+
+	var keys = make([]hamt64.KeyI, n)
+	for i := range keys {
+		var khv = base | hamt64.HashVal(i)<<shift
+		keys[i] = CollidingKey{khv, "deep" + strconv.Itoa(i)}
+	}
+	return keys
+}
+
+// StringKeys returns n distinct hamt64.StringKey keys built from prefix.
+func StringKeys(prefix string, n int) []hamt64.KeyI {
+	var keys = make([]hamt64.KeyI, n)
+	for i := range keys {
+		keys[i] = hamt64.StringKey(prefix + strconv.Itoa(i))
+	}
+	return keys
+}
+
+// foreignKey is a KeyI type no one else knows about; no other KeyI should
+// ever consider itself equal to one.
+type foreignKey struct{}
+
+func (foreignKey) Hash() hamt64.HashVal    { return 0 }
+func (foreignKey) Equals(hamt64.KeyI) bool { return false }
+
+// otherKeys are keys of every KeyI type provided by the hamt64 and hamttest
+// packages, plus foreignKey. CheckKey verifies that none of them compares
+// equal to a key of a different type.
+var otherKeys = []hamt64.KeyI{
+	hamt64.ByteSliceKey("0"),
+	hamt64.StringKey("0"),
+	hamt64.Int32Key(0),
+	hamt64.Int64Key(0),
+	hamt64.Uint32Key(0),
+	hamt64.Uint64Key(0),
+	CollidingKey{0, "0"},
+	foreignKey{},
+}
+
+// CheckKey verifies the Equals/Hash contract of a KeyI implementation. The
+// gen function must return a newly constructed key for each call, such that
+// gen(i) and gen(j) are equal if and only if i == j. CheckKey calls gen for i
+// in [0, n).
+//
+// CheckKey verifies that:
+//   - a key equals itself and an independently generated equal key, in both
+//     directions;
+//   - equal keys have equal HashVals, and a key's HashVal does not change;
+//   - distinct keys do not compare equal;
+//   - a key never compares equal to a key of another type, and comparing
+//     them does not panic.
+func CheckKey(t testing.TB, gen func(i int) hamt64.KeyI, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		var a, b = gen(i), gen(i)
+
+		if !a.Equals(a) {
+			t.Fatalf("CheckKey: gen(%d)=%v does not equal itself", i, a)
+		}
+		if !a.Equals(b) || !b.Equals(a) {
+			t.Fatalf("CheckKey: gen(%d)=%v and gen(%d)=%v are not equal",
+				i, a, i, b)
+		}
+		if a.Hash() != b.Hash() {
+			t.Fatalf("CheckKey: equal keys %v and %v have hashes %s != %s",
+				a, b, a.Hash(), b.Hash())
+		}
+		if a.Hash() != a.Hash() {
+			t.Fatalf("CheckKey: gen(%d)=%v Hash() is not stable", i, a)
+		}
+
+		if i > 0 {
+			var prev = gen(i - 1)
+			if a.Equals(prev) || prev.Equals(a) {
+				t.Fatalf("CheckKey: distinct keys gen(%d)=%v and gen(%d)=%v "+
+					"compare equal", i, a, i-1, prev)
+			}
+		}
+
+		for _, other := range otherKeys {
+			if reflect.TypeOf(other) == reflect.TypeOf(a) {
+				continue
+			}
+			if equalsNoPanic(t, a, other) || equalsNoPanic(t, other, a) {
+				t.Fatalf("CheckKey: gen(%d)=%v (%T) compares equal to %v (%T)",
+					i, a, a, other, other)
+			}
+		}
+	}
+}
+
+func equalsNoPanic(t testing.TB, a, b hamt64.KeyI) (equal bool) {
+	t.Helper()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("CheckKey: %v (%T).Equals(%v (%T)) panicked: %v",
+				a, a, b, b, r)
+		}
+	}()
+
+	return a.Equals(b)
+}
@@ -0,0 +1,219 @@
+package hamt64
+
+// Conflict is a key that Merge3() found changed differently in ours and in
+// theirs. The In fields say whether the key is in each Hamt, and the other
+// fields are its values there (nil if not).
+type Conflict struct {
+	Key                      KeyI
+	Base, Ours, Theirs       interface{}
+	InBase, InOurs, InTheirs bool
+}
+
+// Resolver decides a Conflict for Merge3(). It returns the merged value of the
+// key and true, or false to leave the key out of the merged Hamt.
+type Resolver func(Conflict) (interface{}, bool)
+
+// KeepOurs is a Resolver that settles every Conflict in favor of ours.
+func KeepOurs(c Conflict) (interface{}, bool) {
+	return c.Ours, c.InOurs
+}
+
+// KeepTheirs is a Resolver that settles every Conflict in favor of theirs.
+func KeepTheirs(c Conflict) (interface{}, bool) {
+	return c.Theirs, c.InTheirs
+}
+
+// Merge3 merges the changes made from the base Hamt to the theirs Hamt into
+// the ours Hamt, and returns the merged HamtFunctional with every Conflict it
+// had to resolve, in Range() order. A key that only one side changed gets
+// that side's value (or is deleted); a key that both sides changed the same
+// way keeps it; and a key both sides changed differently is a Conflict, which
+// the resolver decides. A nil resolver is KeepOurs.
+//
+// Merge3 walks the three Hamts at once, like Diff(). A subtree that ours and
+// theirs share, or that theirs shares with base, is ours already. Where ours
+// shares a subtree with base, theirs' subtree is grafted into the result in
+// its place, provided the three Hamts use the same table option. So when ours
+// and theirs were both derived from base, the cost of Merge3 depends on the
+// changes, not the size of the Hamts; and the result shares its tables with
+// both.
+//
+// If ours is a HamtTransient it is converted with ToFunctional(), so it must
+// no longer be modified; none of the three may be modified during the Merge3.
+func Merge3(base, ours, theirs Hamt, resolve Resolver) (Hamt, []Conflict) {
+	var b, o, t = baseOf(base), baseOf(ours), baseOf(theirs)
+	if b == nil || o == nil || t == nil {
+		panic("Merge3: Hamts must be a HamtFunctional or HamtTransient")
+	}
+	if resolve == nil {
+		resolve = KeepOurs
+	}
+
+	var m = &merger{
+		h:       ours.ToFunctional().(*HamtFunctional),
+		resolve: resolve,
+		graft: b.nograde == o.nograde && o.nograde == t.nograde &&
+			b.startFixed == o.startFixed && o.startFixed == t.startFixed &&
+			b.keyless == o.keyless && o.keyless == t.keyless,
+	}
+	m.mergeTables(0, &b.root, &o.root, &t.root)
+
+	return m.h, m.conflicts
+}
+
+// merger holds the state of a Merge3(). The result, h, starts out as ours and
+// gets theirs' changes applied with functional Put()s, Del()s, and grafts.
+type merger struct {
+	h         *HamtFunctional
+	resolve   Resolver
+	conflicts []Conflict
+	graft     bool
+}
+
+// mergeChild returns the node in slot idx of a table of the given depth at
+// n's place in the Hamt. A leaf stands for a table with only itself in it.
+func mergeChild(n nodeI, depth, idx uint) nodeI {
+	switch x := n.(type) {
+	case tableI:
+		return x.get(idx)
+	case leafI:
+		if x.Hash().Index(depth) == idx {
+			return x
+		}
+	}
+	return nil
+}
+
+// mergeTables merges the slots of tables of the given depth, any of which
+// may be a leaf or nil instead; see mergeChild().
+func (m *merger) mergeTables(depth uint, b, o, t nodeI) {
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		m.mergeNodes(depth+1,
+			mergeChild(b, depth, idx),
+			mergeChild(o, depth, idx),
+			mergeChild(t, depth, idx))
+	}
+}
+
+// mergeNodes merges the nodes found in the same slot of the three Hamts; a
+// table there would be of the given depth.
+func (m *merger) mergeNodes(depth uint, b, o, t nodeI) {
+	if o == t || b == t {
+		return // ours already
+	}
+
+	var ot, oIsTable = o.(tableI)
+	var tt, tIsTable = t.(tableI)
+
+	if b == o {
+		if m.graft && oIsTable && tIsTable {
+			m.h = m.h.graft(depth, ot, tt)
+			return
+		}
+		diffNodes(o, t, func(c Change) bool {
+			m.apply(c.Key, c.New, c.Kind != Removed)
+			return true
+		})
+		return
+	}
+
+	var _, bIsTable = b.(tableI)
+	if bIsTable || oIsTable || tIsTable {
+		m.mergeTables(depth, b, o, t)
+		return
+	}
+
+	m.mergeKeys(b, o, t)
+}
+
+// mergeKeys does a key by key three way merge of nodes that are all leafs or
+// nil, so hold very few KeyVals.
+func (m *merger) mergeKeys(b, o, t nodeI) {
+	var bkvs = subtreeKeyVals(b)
+	var okvs = subtreeKeyVals(o)
+	var tkvs = subtreeKeyVals(t)
+
+	var merge = func(key KeyI) {
+		var bkv, inBase = findKeyVal(bkvs, key)
+		var okv, inOurs = findKeyVal(okvs, key)
+		var tkv, inTheirs = findKeyVal(tkvs, key)
+
+		switch {
+		case sameEntry(inTheirs, tkv.Val, inBase, bkv.Val):
+			// theirs did not change it
+		case sameEntry(inOurs, okv.Val, inBase, bkv.Val):
+			m.apply(key, tkv.Val, inTheirs)
+		case sameEntry(inOurs, okv.Val, inTheirs, tkv.Val):
+			// both changed it the same way
+		default:
+			var c = Conflict{
+				Key:  key,
+				Base: bkv.Val, Ours: okv.Val, Theirs: tkv.Val,
+				InBase: inBase, InOurs: inOurs, InTheirs: inTheirs,
+			}
+			m.conflicts = append(m.conflicts, c)
+			var val, keep = m.resolve(c)
+			if keep || inOurs {
+				m.apply(key, val, keep)
+			}
+		}
+	}
+
+	for _, kv := range okvs {
+		merge(kv.Key)
+	}
+	for _, kv := range tkvs {
+		if _, inOurs := findKeyVal(okvs, kv.Key); !inOurs {
+			merge(kv.Key)
+		}
+	}
+	// keys only in base were deleted by both
+}
+
+// sameEntry returns true if a key is in two Hamts with equal values, or in
+// neither.
+func sameEntry(in1 bool, v1 interface{}, in2 bool, v2 interface{}) bool {
+	return in1 == in2 && (!in1 || valuesEqual(v1, v2))
+}
+
+// apply Put()s the (key,val) pair into the result if put is set, or Del()s the
+// key if not.
+func (m *merger) apply(key KeyI, val interface{}, put bool) {
+	var nh Hamt
+	if put {
+		nh, _ = m.h.Put(key, val)
+	} else {
+		nh, _, _ = m.h.Del(key)
+	}
+	m.h = nh.(*HamtFunctional)
+}
+
+// graft returns a copy of the HamtFunctional with oldTable, of the given
+// depth, replaced by newTable, which holds the same range of HashVals. Only
+// the tables above oldTable are copied; newTable is shared.
+//
+// The parent of oldTable keeps the same number of entries, so no table needs
+// to be upgraded, downgraded, or collapsed.
+func (h *HamtFunctional) graft(
+	depth uint,
+	oldTable, newTable tableI,
+) *HamtFunctional {
+	var nh = new(HamtFunctional)
+	*nh = *h
+
+	var hashPath = oldTable.Hash()
+	var path = newTableSlice()
+	var cur tableI = &h.root
+	for d := uint(0); d < depth; d++ {
+		path.push(cur)
+		cur = cur.get(hashPath.Index(d)).(tableI)
+	}
+	_ = assertOn && assert(cur == oldTable,
+		"graft(): oldTable is not in the Hamt")
+
+	nh.replaceTree(oldTable, newTable)
+	nh.nentries = nh.nentries - oldTable.count() + newTable.count()
+	nh.persist(oldTable, newTable, path)
+
+	return nh
+}
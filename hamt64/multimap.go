@@ -0,0 +1,175 @@
+package hamt64
+
+// MultiMap maps each key to a Set of values. It is a Hamt of key->*Set, so
+// both levels share structure: a functional Add() or Remove() copies the path
+// to the key in the outer Hamt and the path to the value in that key's Set,
+// and nothing else.
+//
+// Like the Hamt, a MultiMap is either functional, where Add(), Remove(), and
+// RemoveAll() return a new MultiMap and leave the original unaltered, or
+// transient, where they modify the MultiMap in place and return it.
+//
+// The values must implement KeyI, since they are stored in a Set.
+type MultiMap struct {
+	hamt   Hamt
+	tblOpt int
+	count  uint
+}
+
+// NewMultiMap constructs an empty MultiMap.
+//
+// When the functional argument is true the MultiMap is functional, otherwise
+// it is transient. The tblOpt argument is the table option defined by the
+// constants HybridTables, SparseTables, xor FixedTables; it is used for the
+// outer Hamt and for every value Set.
+func NewMultiMap(functional bool, tblOpt int) *MultiMap {
+	var m = new(MultiMap)
+	m.hamt = New(functional, tblOpt)
+	m.tblOpt = tblOpt
+	return m
+}
+
+// IsFunctional returns true if the MultiMap is functional, and false if it is
+// transient.
+func (m *MultiMap) IsFunctional() bool {
+	var _, isFunctional = m.hamt.(*HamtFunctional)
+	return isFunctional
+}
+
+// IsEmpty returns true if the MultiMap has no keys.
+func (m *MultiMap) IsEmpty() bool {
+	return m.hamt.IsEmpty()
+}
+
+// Nkeys returns the number of keys with at least one value.
+func (m *MultiMap) Nkeys() uint {
+	return m.hamt.Nentries()
+}
+
+// Count returns the total number of (key,value) pairs in the MultiMap.
+func (m *MultiMap) Count() uint {
+	return m.count
+}
+
+// Values returns the Set of values for the key, or nil if the key has no
+// values.
+//
+// The Set of a transient MultiMap is the one the MultiMap modifies in place;
+// it should not be modified by the caller.
+func (m *MultiMap) Values(key KeyI) *Set {
+	var v, found = m.hamt.Get(key)
+	if !found {
+		return nil
+	}
+	return v.(*Set)
+}
+
+// Contains returns true if the value is one of the values for the key.
+func (m *MultiMap) Contains(key, val KeyI) bool {
+	var s = m.Values(key)
+	return s != nil && s.Contains(val)
+}
+
+// Add puts the value into the Set of values for the key. It returns the
+// resulting MultiMap and a bool indicating if the value was added (true) or
+// was already there (false).
+//
+// If the value was already there the original MultiMap is returned.
+func (m *MultiMap) Add(key, val KeyI) (*MultiMap, bool) {
+	var s = m.Values(key)
+	var newKey = s == nil
+	if newKey {
+		s = NewSet(m.IsFunctional(), m.tblOpt)
+	}
+
+	var ns, added = s.Add(val)
+	if !added {
+		return m, false
+	}
+
+	var nm = m.modifiable()
+	if newKey || ns != s {
+		nm.hamt, _ = nm.hamt.Put(key, ns)
+	}
+	nm.count++
+
+	return nm, true
+}
+
+// Remove takes the value out of the Set of values for the key; if that was
+// the last value, the key is removed too. It returns the resulting MultiMap
+// and a bool indicating if the value was found.
+//
+// If the value was not found the original MultiMap is returned.
+func (m *MultiMap) Remove(key, val KeyI) (*MultiMap, bool) {
+	var s = m.Values(key)
+	if s == nil {
+		return m, false
+	}
+
+	var ns, removed = s.Remove(val)
+	if !removed {
+		return m, false
+	}
+
+	var nm = m.modifiable()
+	if ns.IsEmpty() {
+		nm.hamt, _, _ = nm.hamt.Del(key)
+	} else if ns != s {
+		nm.hamt, _ = nm.hamt.Put(key, ns)
+	}
+	nm.count--
+
+	return nm, true
+}
+
+// RemoveAll removes the key and every value for it. It returns the resulting
+// MultiMap and the Set of values that were removed, or nil if the key had no
+// values.
+//
+// If the key was not found the original MultiMap is returned.
+func (m *MultiMap) RemoveAll(key KeyI) (*MultiMap, *Set) {
+	var s = m.Values(key)
+	if s == nil {
+		return m, nil
+	}
+
+	var nm = m.modifiable()
+	nm.hamt, _, _ = nm.hamt.Del(key)
+	nm.count -= s.Len()
+
+	return nm, s
+}
+
+// modifiable returns a copy of a functional MultiMap, or the transient
+// MultiMap itself.
+func (m *MultiMap) modifiable() *MultiMap {
+	if !m.IsFunctional() {
+		return m
+	}
+	var nm = new(MultiMap)
+	*nm = *m
+	return nm
+}
+
+// Range executes the given function for every (key,value) pair in the
+// MultiMap. The values for one key are visited together. The iteration stops
+// if fn returns false.
+func (m *MultiMap) Range(fn func(key, val KeyI) bool) {
+	m.hamt.Range(func(key KeyI, v interface{}) bool {
+		var keepOn = true
+		v.(*Set).Range(func(val KeyI) bool {
+			keepOn = fn(key, val)
+			return keepOn
+		})
+		return keepOn
+	})
+}
+
+// RangeKeys executes the given function for every key in the MultiMap with
+// the Set of values for that key. The iteration stops if fn returns false.
+func (m *MultiMap) RangeKeys(fn func(key KeyI, vals *Set) bool) {
+	m.hamt.Range(func(key KeyI, v interface{}) bool {
+		return fn(key, v.(*Set))
+	})
+}
@@ -0,0 +1,324 @@
+package hamt64
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Observable publishes a succession of HamtFunctional versions and tells its
+// subscribers about every change. Each Put(), Del(), or Update() that changes
+// anything makes a new version, numbered one more than the last; the
+// Observable starts at version 0. Get() never waits, not even for a writer
+// blocked by a subscriber.
+//
+// Subscribers receive Events on a buffered channel. What happens when a
+// subscriber falls behind and its buffer is full is up to its BackPressure
+// policy. Subscribers can also CatchUp() from an earlier version; the changes
+// since then are computed by Diff()ing the two versions, so the Observable
+// keeps the last few versions around for that.
+type Observable struct {
+	current atomic.Value // observed
+	lock    sync.Mutex
+	hamt    Hamt
+	version uint64
+	history []Hamt // history[i] is version first+i
+	first   uint64
+	keep    int
+	subs    map[*Subscription]bool
+}
+
+type observed struct {
+	hamt    Hamt
+	version uint64
+}
+
+// Event is a Change made by the given version of an Observable.
+type Event struct {
+	Change
+	Version uint64
+}
+
+// BackPressure is the policy for a Subscription whose buffer is full.
+type BackPressure int
+
+const (
+	// Block makes the Observable's writers wait until there is room in the
+	// buffer. Every Event is delivered, in order, but one slow subscriber
+	// slows every writer down.
+	Block BackPressure = iota
+	// Drop discards the Events that do not fit in the buffer and counts
+	// them. Writers never wait.
+	Drop
+	// Coalesce has the Subscription catch up on its own goroutine. Whenever
+	// it has delivered everything, it Diff()s the last version it delivered
+	// against the current one and delivers those Changes, all stamped with
+	// the current version. Writers never wait and no change is lost, but a
+	// key changed several times while the subscriber was behind is only
+	// delivered once.
+	Coalesce
+)
+
+// BackPressureName maps Block, Drop, and Coalesce to their names.
+var BackPressureName = [3]string{"Block", "Drop", "Coalesce"}
+
+// ErrVersionGone is returned by CatchUp() for a version that the Observable
+// no longer keeps.
+var ErrVersionGone = errors.New("Observable: version is no longer kept")
+
+// Subscription is a subscriber's registration with an Observable. The Events
+// are received from C, which is closed by Unsubscribe().
+type Subscription struct {
+	// dropped is first so that it is 64 bit aligned, as atomic requires, on
+	// 32 bit platforms too.
+	dropped uint64
+
+	C <-chan Event
+
+	c      chan Event
+	obs    *Observable
+	policy BackPressure
+	done   chan struct{}
+	once   sync.Once
+
+	// Coalesce only
+	notify chan struct{}
+	last   Hamt
+}
+
+// NewObservable constructs a new Observable starting with the given Hamt as
+// version 0. It keeps the keep most recent versions for CatchUp(); at least
+// the current one.
+//
+// If the Hamt is a HamtTransient it is converted with ToFunctional(), so it
+// must no longer be modified.
+func NewObservable(h Hamt, keep int) *Observable {
+	if keep < 1 {
+		keep = 1
+	}
+	var o = new(Observable)
+	o.hamt = h.ToFunctional()
+	o.keep = keep
+	o.history = []Hamt{o.hamt}
+	o.subs = make(map[*Subscription]bool)
+	o.current.Store(observed{o.hamt, 0})
+	return o
+}
+
+// Get returns the current version of the Observable and its number.
+func (o *Observable) Get() (Hamt, uint64) {
+	var cur = o.current.Load().(observed)
+	return cur.hamt, cur.version
+}
+
+// Put stores a new (key,value) pair. It returns the new version number and
+// true if the key was added, or false if the value was replaced. If the key
+// already has an equal value, nothing is published and the current version
+// number is returned.
+func (o *Observable) Put(key KeyI, val interface{}) (uint64, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var old, found = o.hamt.Get(key)
+	if found && valuesEqual(old, val) {
+		return o.version, false
+	}
+	var nh, added = o.hamt.Put(key, val)
+
+	var c = Change{Kind: Added, Key: key, New: val}
+	if found {
+		c.Kind = Modified
+		c.Old = old
+	}
+
+	o.publish(nh, []Change{c})
+	return o.version, added
+}
+
+// Del removes the key. It returns the new version number, the value of the
+// key, and true if it was found; or the current version number, nil, and
+// false if not.
+func (o *Observable) Del(key KeyI) (uint64, interface{}, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var nh, val, deleted = o.hamt.Del(key)
+	if deleted {
+		o.publish(nh, []Change{{Kind: Removed, Key: key, Old: val}})
+	}
+	return o.version, val, deleted
+}
+
+// Update replaces the current version with the result of fn, which is given
+// the current version, and returns the new version number. The Events are
+// found by Diff()ing the two. If fn returns the Hamt it was given, nothing is
+// published and the current version number is returned.
+//
+// fn is called with the Observable locked; it must not use the Observable.
+func (o *Observable) Update(fn func(Hamt) Hamt) uint64 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var nh = fn(o.hamt).ToFunctional()
+	if nh == o.hamt {
+		return o.version
+	}
+
+	var changes []Change
+	Diff(o.hamt, nh, func(c Change) bool {
+		changes = append(changes, c)
+		return true
+	})
+
+	o.publish(nh, changes)
+	return o.version
+}
+
+// publish makes nh the next version and delivers its changes. The Observable
+// must be locked.
+func (o *Observable) publish(nh Hamt, changes []Change) {
+	o.hamt = nh
+	o.version++
+	o.current.Store(observed{nh, o.version})
+
+	o.history = append(o.history, nh)
+	if len(o.history) > o.keep {
+		var n = len(o.history) - o.keep
+		o.history = append(o.history[:0], o.history[n:]...)
+		o.first += uint64(n)
+	}
+
+	for s := range o.subs {
+		s.deliver(changes, o.version)
+	}
+}
+
+// CatchUp calls fn with an Event for every Change from version since to the
+// current version, until fn returns false. The Events are all stamped with
+// the current version, which is returned.
+//
+// If the version is no longer kept, it returns the current version and
+// ErrVersionGone; then the subscriber should start over from Get().
+func (o *Observable) CatchUp(
+	since uint64,
+	fn func(Event) bool,
+) (uint64, error) {
+	o.lock.Lock()
+	var cur, version = o.hamt, o.version
+	var old Hamt
+	if since >= o.first && since <= o.version {
+		old = o.history[since-o.first]
+	}
+	o.lock.Unlock()
+
+	if old == nil {
+		return version, errors.Wrapf(ErrVersionGone, "version %d", since)
+	}
+
+	Diff(old, cur, func(c Change) bool {
+		return fn(Event{c, version})
+	})
+	return version, nil
+}
+
+// Subscribe registers a new Subscription, with a buffer of the given size
+// and BackPressure policy, which receives the Events of every version after
+// the current one.
+func (o *Observable) Subscribe(buffer int, policy BackPressure) *Subscription {
+	var s = new(Subscription)
+	s.c = make(chan Event, buffer)
+	s.C = s.c
+	s.obs = o
+	s.policy = policy
+	s.done = make(chan struct{})
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if policy == Coalesce {
+		s.notify = make(chan struct{}, 1)
+		s.last = o.hamt
+		go s.pump()
+	}
+	o.subs[s] = true
+
+	return s
+}
+
+// Unsubscribe stops the Subscription and closes its channel.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		// closing done first releases a writer blocked on s.c
+		close(s.done)
+
+		s.obs.lock.Lock()
+		delete(s.obs.subs, s)
+		s.obs.lock.Unlock()
+
+		if s.policy != Coalesce {
+			close(s.c)
+		}
+	})
+}
+
+// Dropped returns the number of Events a Drop Subscription has discarded. It
+// does not wait for the Observable, so it may be called while a writer is
+// blocked by another Subscription.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// deliver hands the changes of a version to the Subscription according to its
+// policy. The Observable is locked.
+func (s *Subscription) deliver(changes []Change, version uint64) {
+	switch s.policy {
+	case Block:
+		for _, c := range changes {
+			select {
+			case s.c <- Event{c, version}:
+			case <-s.done:
+				return
+			}
+		}
+	case Drop:
+		for _, c := range changes {
+			select {
+			case s.c <- Event{c, version}:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+	case Coalesce:
+		select {
+		case s.notify <- struct{}{}:
+		default: // already notified
+		}
+	}
+}
+
+// pump delivers the Events of a Coalesce Subscription.
+func (s *Subscription) pump() {
+	defer close(s.c)
+	for {
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+
+		var cur, version = s.obs.Get()
+		var more = Diff(s.last, cur, func(c Change) bool {
+			select {
+			case s.c <- Event{c, version}:
+				return true
+			case <-s.done:
+				return false
+			}
+		})
+		if !more {
+			return
+		}
+		s.last = cur
+	}
+}
@@ -0,0 +1,174 @@
+package hamt64
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Every table keeps a count of the KeyVal pairs in the subtree rooted at it.
+// Put() and Del() adjust the count of every table on the path to the modified
+// leaf, and the functions that build tables from scratch (createTable(),
+// upgradeToFixedTable(), and downgradeToSparseTable()) sum up the counts of
+// the nodes they are built from. With the counts, finding the i'th KeyVal pair
+// in hash order (the order Range() visits them in) is a walk down one path.
+
+// nodeCount returns the number of KeyVal pairs in the subtree rooted at n.
+func nodeCount(n nodeI) uint {
+	switch x := n.(type) {
+	case nil:
+		return 0
+	case tableI:
+		return x.count()
+	}
+	if size, isCollision := collisionSize(n); isCollision {
+		return size
+	}
+	return 1
+}
+
+// countEntries returns the number of KeyVal pairs in the subtrees of ents.
+func countEntries(ents []tableEntry) uint {
+	var n uint
+	for _, ent := range ents {
+		n += nodeCount(ent.node)
+	}
+	return n
+}
+
+// incrCount adds one to (incr == true), or subtracts one from (incr == false),
+// the count of t.
+func incrCount(t tableI, incr bool) {
+	if incr {
+		t.setCount(t.count() + 1)
+	} else {
+		t.setCount(t.count() - 1)
+	}
+}
+
+// Nth returns the i'th KeyVal pair in hash order, the order Range() visits the
+// KeyVal pairs in, and true; or an empty KeyVal and false if i >= Nentries().
+func (h *hamtBase) Nth(i uint) (KeyVal, bool) {
+	if i >= h.nentries {
+		return KeyVal{}, false
+	}
+
+	var n nodeI = &h.root
+	for {
+		var t, isTable = n.(tableI)
+		if !isTable {
+			return n.(leafI).keyVals()[i], true
+		}
+
+		var next = t.iter()
+		for n = next(); n != nil; n = next() {
+			var cnt = nodeCount(n)
+			if i < cnt {
+				break
+			}
+			i -= cnt
+		}
+	}
+}
+
+// Rank returns the position of the key in hash order, such that
+// h.Nth(h.Rank(key)) returns the key, and true; or 0 and false if the key is
+// not in the Hamt.
+func (h *hamtBase) Rank(key KeyI) (uint, bool) {
+	var hv = key.Hash()
+	var rank uint
+
+	var t tableI = &h.root
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		var idx = hv.Index(depth)
+
+		var next = t.iter()
+		for n := next(); n != nil; n = next() {
+			if n.Hash().Index(depth) >= idx {
+				break
+			}
+			rank += nodeCount(n)
+		}
+
+		switch x := t.get(idx).(type) {
+		case nil:
+			return 0, false
+		case tableI:
+			t = x
+		case leafI:
+			for j, kv := range x.keyVals() {
+				if kv.Key.Equals(key) {
+					return rank + uint(j), true
+				}
+			}
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// RandomEntry returns a KeyVal pair chosen uniformly at random, using src as
+// the source of randomness, and true; or an empty KeyVal and false if the Hamt
+// is empty.
+func (h *hamtBase) RandomEntry(src rand.Source) (KeyVal, bool) {
+	if h.nentries == 0 {
+		return KeyVal{}, false
+	}
+	return h.Nth(uint(rand.New(src).Int63n(int64(h.nentries))))
+}
+
+// Sample returns n distinct KeyVal pairs chosen uniformly at random, using src
+// as the source of randomness, in hash order. If n >= Nentries() every KeyVal
+// pair is returned.
+func (h *hamtBase) Sample(n uint, src rand.Source) []KeyVal {
+	if n > h.nentries {
+		n = h.nentries
+	}
+
+	// Robert Floyd's algorithm for choosing n of [0, nentries)
+	var rnd = rand.New(src)
+	var chosen = make(map[uint]bool, n)
+	var positions = make([]uint, 0, n)
+	for j := h.nentries - n; j < h.nentries; j++ {
+		var pos = uint(rnd.Int63n(int64(j + 1)))
+		if chosen[pos] {
+			pos = j
+		}
+		chosen[pos] = true
+		positions = append(positions, pos)
+	}
+
+	sort.Slice(positions, func(a, b int) bool {
+		return positions[a] < positions[b]
+	})
+
+	var kvs = make([]KeyVal, len(positions))
+	for i, pos := range positions {
+		kvs[i], _ = h.Nth(pos)
+	}
+
+	return kvs
+}
+
+// Count returns the number of KeyVal pairs in the subtree rooted at the node.
+// It is O(1), except below the root table of a CtrieSnapshot, whose tables do
+// not count their subtrees; there Count() walks the subtree.
+func (v nodeView) Count() uint {
+	if v.node == nil {
+		return 0
+	}
+	if v.ctrie == nil {
+		return nodeCount(v.node)
+	}
+	if v.depth == 0 {
+		var n, _ = v.ctrie.readRoot().gen.settle(true)
+		return n
+	}
+
+	var n uint
+	v.walk(LeafsOnly, func(l nodeView) bool {
+		n += l.Nentries()
+		return true
+	})
+	return n
+}
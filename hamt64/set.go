@@ -0,0 +1,221 @@
+package hamt64
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Set is a set of keys built on the same trie as the Hamt. Like the Hamt, a
+// Set is either functional, where Add() and Remove() return a new Set and
+// leave the original unaltered, or transient, where Add() and Remove() modify
+// the Set in place and return it.
+//
+// A Set constructed by NewSet() stores its keys in keyless leafs, so it does
+// not spend a value slot on every key the way a Hamt of key->struct{}{} does.
+// A Set returned by KeySet() is a view of the Hamt's own trie; see
+// KeySet().
+type Set struct {
+	hamt Hamt
+}
+
+// NewSet constructs an empty Set.
+//
+// When the functional argument is true the Set is functional, otherwise it is
+// transient. The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewSet(functional bool, tblOpt int) *Set {
+	if functional {
+		var h = NewFunctional(tblOpt)
+		h.keyless = true
+		return &Set{h}
+	}
+	var h = NewTransient(tblOpt)
+	h.keyless = true
+	return &Set{h}
+}
+
+// IsFunctional returns true if the Set is functional, and false if it is
+// transient.
+func (s *Set) IsFunctional() bool {
+	var _, isFunctional = s.hamt.(*HamtFunctional)
+	return isFunctional
+}
+
+// ToFunctional returns a functional Set sharing the same trie. As with
+// Hamt.ToFunctional(), the original transient Set must not be modified
+// afterwards.
+func (s *Set) ToFunctional() *Set {
+	return &Set{s.hamt.ToFunctional()}
+}
+
+// ToTransient returns a transient Set sharing the same trie. As with
+// Hamt.ToTransient(), use DeepCopy() first if the original functional Set is
+// still in use.
+func (s *Set) ToTransient() *Set {
+	return &Set{s.hamt.ToTransient()}
+}
+
+// DeepCopy copies the Set and every table it contains. The copy is functional
+// no matter what the original was.
+func (s *Set) DeepCopy() *Set {
+	return &Set{s.hamt.DeepCopy()}
+}
+
+// IsEmpty returns true if the Set has no keys.
+func (s *Set) IsEmpty() bool {
+	return s.hamt.IsEmpty()
+}
+
+// Len returns the number of keys in the Set.
+func (s *Set) Len() uint {
+	return s.hamt.Nentries()
+}
+
+// Contains returns true if the key is in the Set.
+func (s *Set) Contains(key KeyI) bool {
+	var _, found = s.hamt.Get(key)
+	return found
+}
+
+// Add puts the key into the Set. It returns the resulting Set and a bool
+// indicating if the key was added (true) or was already there (false).
+//
+// If the key was already there the original Set is returned.
+func (s *Set) Add(key KeyI) (*Set, bool) {
+	if s.Contains(key) {
+		return s, false
+	}
+	var h, _ = s.hamt.Put(key, nil)
+	return s.with(h), true
+}
+
+// Remove takes the key out of the Set. It returns the resulting Set and a bool
+// indicating if the key was found.
+//
+// If the key was not found the original Set is returned.
+func (s *Set) Remove(key KeyI) (*Set, bool) {
+	var h, _, found = s.hamt.Del(key)
+	if !found {
+		return s, false
+	}
+	return s.with(h), true
+}
+
+// with returns the Set for the modified Hamt h. A transient Set keeps its
+// identity.
+func (s *Set) with(h Hamt) *Set {
+	if s.IsFunctional() {
+		return &Set{h}
+	}
+	s.hamt = h
+	return s
+}
+
+// Range executes the given function for every key in the Set, in the same
+// order Hamt.Range() would visit them. The iteration stops if fn returns
+// false.
+func (s *Set) Range(fn func(KeyI) bool) {
+	s.hamt.Range(func(key KeyI, _ interface{}) bool {
+		return fn(key)
+	})
+}
+
+// Keys returns a slice of every key in the Set, in Range() order.
+func (s *Set) Keys() []KeyI {
+	var keys = make([]KeyI, 0, s.Len())
+	s.Range(func(key KeyI) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Union returns the Set of keys that are in s or in o.
+//
+// The result has the mode of s. For a transient s, s itself is modified and
+// returned.
+func (s *Set) Union(o *Set) *Set {
+	var r = s
+	o.Range(func(key KeyI) bool {
+		r, _ = r.Add(key)
+		return true
+	})
+	return r
+}
+
+// Intersect returns the Set of keys that are in both s and o.
+//
+// The result has the mode of s. For a transient s, s itself is modified and
+// returned.
+func (s *Set) Intersect(o *Set) *Set {
+	var drop []KeyI
+	s.Range(func(key KeyI) bool {
+		if !o.Contains(key) {
+			drop = append(drop, key)
+		}
+		return true
+	})
+	return s.removeAll(drop)
+}
+
+// Difference returns the Set of keys that are in s but not in o.
+//
+// The result has the mode of s. For a transient s, s itself is modified and
+// returned.
+func (s *Set) Difference(o *Set) *Set {
+	var drop []KeyI
+	if o.Len() < s.Len() {
+		drop = o.Keys()
+	} else {
+		s.Range(func(key KeyI) bool {
+			if o.Contains(key) {
+				drop = append(drop, key)
+			}
+			return true
+		})
+	}
+	return s.removeAll(drop)
+}
+
+// removeAll removes every key in keys. They are collected before any are
+// removed, because a transient Set must not be modified during Range().
+func (s *Set) removeAll(keys []KeyI) *Set {
+	var r = s
+	for _, key := range keys {
+		r, _ = r.Remove(key)
+	}
+	return r
+}
+
+// IsSubset returns true if every key in s is also in o.
+func (s *Set) IsSubset(o *Set) bool {
+	if s.Len() > o.Len() {
+		return false
+	}
+	var subset = true
+	s.Range(func(key KeyI) bool {
+		subset = o.Contains(key)
+		return subset
+	})
+	return subset
+}
+
+// Equals returns true if s and o contain the same keys.
+func (s *Set) Equals(o *Set) bool {
+	return s.Len() == o.Len() && s.IsSubset(o)
+}
+
+// Stats returns the Stats of the trie underneath the Set.
+func (s *Set) Stats() *Stats {
+	return s.hamt.Stats()
+}
+
+// String returns a string listing the keys of the Set.
+func (s *Set) String() string {
+	var strs = make([]string, 0, s.Len())
+	s.Range(func(key KeyI) bool {
+		strs = append(strs, fmt.Sprint(key))
+		return true
+	})
+	return "Set{" + strings.Join(strs, ", ") + "}"
+}
@@ -0,0 +1,279 @@
+package hamt64
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Software transactional memory over Hamts.
+//
+// A Ref holds a HamtFunctional. Atomically() runs a function that reads and
+// modifies any number of Refs through a Tx; the modifications are buffered in
+// the Tx and either all of them are published when the function returns, or,
+// if another transaction got in the way, none are and the function is run
+// again.
+//
+// Every commit gets a version from a global clock and stamps it on the Refs
+// it publishes. A Tx remembers the clock when it started; reading a Ref
+// stamped later than that means the Tx's earlier reads may be stale, so they
+// are validated before the Tx continues. That way the function never sees an
+// inconsistent set of Refs. The Refs are validated again, under their locks,
+// when the Tx commits.
+//
+// A Ref is unchanged if it holds the same HamtFunctional. If it does not, the
+// Tx only conflicts if a key it read (including the keys it Put() or Del(),
+// whose results depend on the old value) is in the Diff() between the
+// HamtFunctional it read and the current one; or if it used the whole Hamt of
+// the Ref through Tx.Hamt(). Otherwise its buffered Put()s and Del()s are
+// replayed on top of the current HamtFunctional. So transactions modifying
+// different keys of the same Refs do not abort each other.
+
+var stmClock uint64
+var stmRefIds uint64
+
+type refState struct {
+	hamt    Hamt
+	version uint64
+}
+
+// Ref is a transactional reference to a HamtFunctional.
+type Ref struct {
+	id    uint64
+	lock  sync.RWMutex
+	state refState
+}
+
+// NewRef constructs a new Ref holding the given Hamt. If the Hamt is a
+// HamtTransient it is converted with ToFunctional(), so it must no longer be
+// modified.
+func NewRef(h Hamt) *Ref {
+	var r = new(Ref)
+	r.id = atomic.AddUint64(&stmRefIds, 1)
+	r.state.hamt = h.ToFunctional()
+	return r
+}
+
+// Get returns the HamtFunctional the Ref currently holds.
+func (r *Ref) Get() Hamt {
+	return r.load().hamt
+}
+
+func (r *Ref) load() refState {
+	r.lock.RLock()
+	var st = r.state
+	r.lock.RUnlock()
+	return st
+}
+
+// stmConflict is panic()ed by a Tx that must be restarted and recover()ed
+// by Atomically().
+type stmConflict struct{}
+
+// txRef is what a Tx knows about one Ref.
+type txRef struct {
+	ref  *Ref
+	read refState  // the state of the Ref the Tx read
+	view Hamt      // read.hamt with ops applied
+	ops  []BatchOp // the buffered Put()s and Del()s
+	keys *Set      // the keys the Tx read
+	full bool      // the Tx read the whole Hamt
+}
+
+// Tx is a transaction started by Atomically(). It must only be used by the
+// function Atomically() passed it to, and only until that function returns.
+type Tx struct {
+	version uint64
+	refs    map[*Ref]*txRef
+}
+
+// Atomically runs fn in a new Tx, then commits the Tx; publishing all of its
+// Put()s and Del()s at once. If the Tx conflicts with another, either while
+// fn runs or when it commits, fn is run again in a new Tx. So fn may run
+// more than once and it should have no side effects outside of the Tx.
+//
+// If fn returns an error, the Tx is discarded and Atomically returns the
+// error.
+func Atomically(fn func(tx *Tx) error) error {
+	for {
+		var tx = &Tx{
+			version: atomic.LoadUint64(&stmClock),
+			refs:    make(map[*Ref]*txRef),
+		}
+
+		var err, conflict = tx.run(fn)
+		if !conflict {
+			if err != nil {
+				return err
+			}
+			if tx.commit() {
+				return nil
+			}
+		}
+
+		runtime.Gosched()
+	}
+}
+
+func (tx *Tx) run(fn func(tx *Tx) error) (err error, conflict bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isConflict := r.(stmConflict); !isConflict {
+				panic(r)
+			}
+			conflict = true
+		}
+	}()
+	return fn(tx), false
+}
+
+// use returns what the Tx knows about the Ref, reading the Ref if this is the
+// first time the Tx uses it.
+func (tx *Tx) use(r *Ref) *txRef {
+	if tr, found := tx.refs[r]; found {
+		return tr
+	}
+
+	var st = r.load()
+	if st.version > tx.version {
+		// r changed after the Tx started; make sure the Refs read so far
+		// are still current as of now.
+		var now = atomic.LoadUint64(&stmClock)
+		for _, tr := range tx.refs {
+			if !tr.validate(tr.ref.load()) {
+				panic(stmConflict{})
+			}
+		}
+		tx.version = now
+	}
+
+	var tr = &txRef{ref: r, read: st, view: st.hamt}
+	tx.refs[r] = tr
+	return tr
+}
+
+// readKey records that the Tx depends on the value of key in tr.
+func (tr *txRef) readKey(key KeyI) {
+	if tr.keys == nil {
+		tr.keys = NewSet(false, HybridTables)
+	}
+	tr.keys, _ = tr.keys.Add(key)
+}
+
+// validate checks whether the Tx's reads of the Ref are still correct given
+// the Ref's current state. If the Ref changed but none of the keys the Tx
+// read did, the Tx is moved on to the current state.
+func (tr *txRef) validate(cur refState) bool {
+	if cur.hamt == tr.read.hamt {
+		return true
+	}
+	if tr.full {
+		return false
+	}
+
+	var ok = true
+	if tr.keys != nil {
+		ok = Diff(tr.read.hamt, cur.hamt, func(c Change) bool {
+			return !tr.keys.Contains(c.Key)
+		})
+	}
+	if !ok {
+		return false
+	}
+
+	tr.read = cur
+	tr.view = cur.hamt
+	for _, op := range tr.ops {
+		if op.Del {
+			tr.view, _, _ = tr.view.Del(op.Key)
+		} else {
+			tr.view, _ = tr.view.Put(op.Key, op.Val)
+		}
+	}
+	return true
+}
+
+// Get retrieves the value related to the key in the Tx's view of the Ref.
+func (tx *Tx) Get(r *Ref, key KeyI) (interface{}, bool) {
+	var tr = tx.use(r)
+	tr.readKey(key)
+	return tr.view.Get(key)
+}
+
+// Put stores a new (key,value) pair in the Tx's view of the Ref. It returns
+// true if the key was added, or false if the value was replaced.
+func (tx *Tx) Put(r *Ref, key KeyI, val interface{}) bool {
+	var tr = tx.use(r)
+	tr.readKey(key)
+
+	var added bool
+	tr.view, added = tr.view.Put(key, val)
+	tr.ops = append(tr.ops, BatchOp{Key: key, Val: val})
+	return added
+}
+
+// Del removes the key from the Tx's view of the Ref. It returns the value of
+// the key and true if it was found, or nil and false if not.
+func (tx *Tx) Del(r *Ref, key KeyI) (interface{}, bool) {
+	var tr = tx.use(r)
+	tr.readKey(key)
+
+	var val interface{}
+	var deleted bool
+	tr.view, val, deleted = tr.view.Del(key)
+	if deleted {
+		tr.ops = append(tr.ops, BatchOp{Key: key, Del: true})
+	}
+	return val, deleted
+}
+
+// Hamt returns the Tx's view of the Ref as a HamtFunctional. Because the Tx
+// may then depend on any key of the Ref, it conflicts with any change to the
+// Ref.
+func (tx *Tx) Hamt(r *Ref) Hamt {
+	var tr = tx.use(r)
+	tr.full = true
+	return tr.view
+}
+
+// commit locks every Ref the Tx used, in id order, validates them, and, if
+// they are all valid, publishes the Tx's modifications under a new version.
+func (tx *Tx) commit() bool {
+	var trs = make([]*txRef, 0, len(tx.refs))
+	for _, tr := range tx.refs {
+		trs = append(trs, tr)
+	}
+	sort.Slice(trs, func(i, j int) bool {
+		return trs[i].ref.id < trs[j].ref.id
+	})
+
+	for _, tr := range trs {
+		tr.ref.lock.Lock()
+	}
+	defer func() {
+		for _, tr := range trs {
+			tr.ref.lock.Unlock()
+		}
+	}()
+
+	var writes bool
+	for _, tr := range trs {
+		if !tr.validate(tr.ref.state) {
+			return false
+		}
+		writes = writes || len(tr.ops) > 0
+	}
+	if !writes {
+		return true
+	}
+
+	var version = atomic.AddUint64(&stmClock, 1)
+	for _, tr := range trs {
+		if len(tr.ops) > 0 {
+			tr.ref.state = refState{tr.view, version}
+		}
+	}
+
+	return true
+}
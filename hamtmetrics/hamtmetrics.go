@@ -0,0 +1,350 @@
+/*
+Package hamtmetrics publishes the statistics of named Hamt data structures via
+the standard library expvar package and via the Prometheus text exposition
+format.
+
+You register a Hamt (hamt32 or hamt64) under a name with a Registry. For
+HamtFunctional data structures, every Put() and Del() returns a new Hamt; so
+you would register the *atomic.Value you publish the current version through
+with RegisterAtomic().
+
+A HamtTransient is modified in place, and a metrics scrape runs on its own
+goroutine, so reading the Stats of a HamtTransient while it is being modified
+is a data race. Only register a HamtTransient itself once it is no longer
+modified. For a live one, register a function with RegisterFunc64() or
+RegisterFunc32() that takes the same lock its writers do.
+
+The statistics are collected with the Hamt QuickStats() method, so they are
+O(1) to collect no matter how large the Hamt is.
+
+	var reg = hamtmetrics.NewRegistry()
+	reg.Register64("users", usersHamt)
+	reg.PublishExpvar("hamt")
+	http.Handle("/metrics", reg)
+
+Only the standard library is used.
+*/
+package hamtmetrics
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lleo/go-hamt/hamt32"
+	"github.com/lleo/go-hamt/hamt64"
+)
+
+// Stats is the hash size independent form of the hamt32.Stats and
+// hamt64.Stats data structures. The two histograms are slices rather than
+// arrays because their lengths depend on the hash size.
+type Stats struct {
+	MaxDepth              uint
+	TableCountsByNentries []uint
+	TableCountsByDepth    []uint
+	Nils                  uint
+	Nodes                 uint
+	Tables                uint
+	Leafs                 uint
+	FixedTables           uint
+	SparseTables          uint
+	FlatLeafs             uint
+	CollisionLeafs        uint
+	MaxCollisionLeafSize  uint
+	KeyVals               uint
+}
+
+// FromStats64 converts a hamt64.Stats to a hamtmetrics.Stats.
+func FromStats64(s *hamt64.Stats) *Stats {
+	return &Stats{
+		MaxDepth:              s.MaxDepth,
+		TableCountsByNentries: append([]uint(nil), s.TableCountsByNentries[:]...),
+		TableCountsByDepth:    append([]uint(nil), s.TableCountsByDepth[:]...),
+		Nils:                  s.Nils,
+		Nodes:                 s.Nodes,
+		Tables:                s.Tables,
+		Leafs:                 s.Leafs,
+		FixedTables:           s.FixedTables,
+		SparseTables:          s.SparseTables,
+		FlatLeafs:             s.FlatLeafs,
+		CollisionLeafs:        s.CollisionLeafs,
+		MaxCollisionLeafSize:  s.MaxCollisionLeafSize,
+		KeyVals:               s.KeyVals,
+	}
+}
+
+// FromStats32 converts a hamt32.Stats to a hamtmetrics.Stats.
+func FromStats32(s *hamt32.Stats) *Stats {
+	return &Stats{
+		MaxDepth:              s.MaxDepth,
+		TableCountsByNentries: append([]uint(nil), s.TableCountsByNentries[:]...),
+		TableCountsByDepth:    append([]uint(nil), s.TableCountsByDepth[:]...),
+		Nils:                  s.Nils,
+		Nodes:                 s.Nodes,
+		Tables:                s.Tables,
+		Leafs:                 s.Leafs,
+		FixedTables:           s.FixedTables,
+		SparseTables:          s.SparseTables,
+		FlatLeafs:             s.FlatLeafs,
+		CollisionLeafs:        s.CollisionLeafs,
+		MaxCollisionLeafSize:  s.MaxCollisionLeafSize,
+		KeyVals:               s.KeyVals,
+	}
+}
+
+// statsFn returns the current Stats of a registered Hamt, or nil if there is
+// no Hamt to report on (eg. an empty *atomic.Value).
+type statsFn func() *Stats
+
+// Registry is a set of named Hamt data structures whose statistics are
+// published together. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	sources map[string]statsFn
+}
+
+// NewRegistry constructs an empty Registry.
+func NewRegistry() *Registry {
+	var r = new(Registry)
+	r.sources = make(map[string]statsFn)
+	return r
+}
+
+// Register64 adds a hamt64.Hamt to the Registry under the given name. If the
+// name was already registered it is replaced. The Hamt must not be modified
+// while it is registered; see RegisterFunc64() for a live HamtTransient.
+func (r *Registry) Register64(name string, h hamt64.Hamt) {
+	r.RegisterFunc64(name, h.QuickStats)
+}
+
+// Register32 adds a hamt32.Hamt to the Registry under the given name. If the
+// name was already registered it is replaced. The Hamt must not be modified
+// while it is registered; see RegisterFunc32() for a live HamtTransient.
+func (r *Registry) Register32(name string, h hamt32.Hamt) {
+	r.RegisterFunc32(name, h.QuickStats)
+}
+
+// RegisterFunc64 adds a function returning the hamt64.Stats of a Hamt to the
+// Registry under the given name. It is called every time the statistics are
+// collected, from the collecting goroutine, so it must synchronize with the
+// writers of the Hamt; eg. call QuickStats() holding their lock. It may
+// return nil if there is nothing to report.
+func (r *Registry) RegisterFunc64(name string, fn func() *hamt64.Stats) {
+	r.register(name, func() *Stats {
+		if s := fn(); s != nil {
+			return FromStats64(s)
+		}
+		return nil
+	})
+}
+
+// RegisterFunc32 adds a function returning the hamt32.Stats of a Hamt to the
+// Registry under the given name; see RegisterFunc64().
+func (r *Registry) RegisterFunc32(name string, fn func() *hamt32.Stats) {
+	r.register(name, func() *Stats {
+		if s := fn(); s != nil {
+			return FromStats32(s)
+		}
+		return nil
+	})
+}
+
+// RegisterAtomic adds a reference to a Hamt to the Registry under the given
+// name. The *atomic.Value must hold either a hamt32.Hamt or a hamt64.Hamt; the
+// value is loaded every time the statistics are collected, so the latest
+// published version of a HamtFunctional is always the one reported.
+func (r *Registry) RegisterAtomic(name string, v *atomic.Value) {
+	r.register(name, func() *Stats {
+		switch h := v.Load().(type) {
+		case hamt64.Hamt:
+			return FromStats64(h.QuickStats())
+		case hamt32.Hamt:
+			return FromStats32(h.QuickStats())
+		}
+		return nil
+	})
+}
+
+func (r *Registry) register(name string, fn statsFn) {
+	r.mu.Lock()
+	r.sources[name] = fn
+	r.mu.Unlock()
+}
+
+// Unregister removes the named Hamt from the Registry.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	delete(r.sources, name)
+	r.mu.Unlock()
+}
+
+// Names returns the sorted list of the names in the Registry.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	var names = make([]string, 0, len(r.sources))
+	for name := range r.sources {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Stats returns the current Stats of every Hamt in the Registry keyed by the
+// name it was registered under.
+func (r *Registry) Stats() map[string]*Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var all = make(map[string]*Stats, len(r.sources))
+	for name, fn := range r.sources {
+		if stats := fn(); stats != nil {
+			all[name] = stats
+		}
+	}
+
+	return all
+}
+
+// PublishExpvar publishes the Registry as a single expvar variable with the
+// given name. The variable is a JSON object mapping each registered name to
+// its Stats.
+//
+// Like expvar.Publish(), it panics if the name is already in use.
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Stats()
+	}))
+}
+
+// ServeHTTP implements the http.Handler interface by writing the Registry in
+// the Prometheus text exposition format (version 0.0.4). The response is
+// rendered before it is sent, so a failure is reported as an error response
+// rather than a truncated one.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes()) // the client is gone; nothing left to report it to
+}
+
+// gauge describes one of the scalar Stats fields exported as a Prometheus
+// gauge.
+type gauge struct {
+	name string
+	help string
+	get  func(*Stats) uint
+}
+
+var gauges = []gauge{
+	{"hamt_keyvals", "Number of key/value pairs stored in the Hamt.",
+		func(s *Stats) uint { return s.KeyVals }},
+	{"hamt_nodes", "Number of tables and leafs in the Hamt.",
+		func(s *Stats) uint { return s.Nodes }},
+	{"hamt_tables", "Number of tables in the Hamt.",
+		func(s *Stats) uint { return s.Tables }},
+	{"hamt_fixed_tables", "Number of fixed tables in the Hamt.",
+		func(s *Stats) uint { return s.FixedTables }},
+	{"hamt_sparse_tables", "Number of sparse tables in the Hamt.",
+		func(s *Stats) uint { return s.SparseTables }},
+	{"hamt_leafs", "Number of leafs in the Hamt.",
+		func(s *Stats) uint { return s.Leafs }},
+	{"hamt_flat_leafs", "Number of flat leafs in the Hamt.",
+		func(s *Stats) uint { return s.FlatLeafs }},
+	{"hamt_collision_leafs", "Number of collision leafs in the Hamt.",
+		func(s *Stats) uint { return s.CollisionLeafs }},
+	{"hamt_max_collision_leaf_size",
+		"Number of key/value pairs in the largest collision leaf in the Hamt.",
+		func(s *Stats) uint { return s.MaxCollisionLeafSize }},
+	{"hamt_nils", "Number of unused table slots in the Hamt.",
+		func(s *Stats) uint { return s.Nils }},
+	{"hamt_max_depth", "Depth of the deepest table in the Hamt.",
+		func(s *Stats) uint { return s.MaxDepth }},
+}
+
+// WritePrometheus writes the Registry to w in the Prometheus text exposition
+// format. Each Hamt is identified by a hamt="name" label.
+//
+// The scalar Stats fields are written as gauges. TableCountsByNentries and
+// TableCountsByDepth are written as the histograms hamt_table_nentries and
+// hamt_table_depth; each table is one observation of its number of entries
+// and of its depth respectively.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	var all = r.Stats()
+
+	var names = make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	for _, g := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", g.name)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{hamt=\"%s\"} %d\n",
+				g.name, escapeLabel(name), g.get(all[name]))
+		}
+	}
+
+	writeHistogram(&b, "hamt_table_nentries",
+		"Distribution of the number of entries per table.",
+		names, all, func(s *Stats) []uint { return s.TableCountsByNentries })
+
+	writeHistogram(&b, "hamt_table_depth",
+		"Distribution of the depth of each table.",
+		names, all, func(s *Stats) []uint { return s.TableCountsByDepth })
+
+	var _, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeHistogram writes a histogram where counts[i] is the number of
+// observations with the value i.
+func writeHistogram(
+	b *strings.Builder,
+	metric, help string,
+	names []string,
+	all map[string]*Stats,
+	get func(*Stats) []uint,
+) {
+	fmt.Fprintf(b, "# HELP %s %s\n", metric, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", metric)
+
+	for _, name := range names {
+		var label = escapeLabel(name)
+		var counts = get(all[name])
+
+		var cum, sum uint
+		for i, n := range counts {
+			cum += n
+			sum += uint(i) * n
+			fmt.Fprintf(b, "%s_bucket{hamt=\"%s\",le=\"%d\"} %d\n",
+				metric, label, i, cum)
+		}
+		fmt.Fprintf(b, "%s_bucket{hamt=\"%s\",le=\"+Inf\"} %d\n",
+			metric, label, cum)
+		fmt.Fprintf(b, "%s_sum{hamt=\"%s\"} %d\n", metric, label, sum)
+		fmt.Fprintf(b, "%s_count{hamt=\"%s\"} %d\n", metric, label, cum)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
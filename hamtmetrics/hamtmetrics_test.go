@@ -0,0 +1,146 @@
+package hamtmetrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lleo/go-hamt/hamt32"
+	"github.com/lleo/go-hamt/hamt64"
+	"github.com/lleo/go-hamt/hamtmetrics"
+)
+
+func buildHamt64(functional bool, num int) hamt64.Hamt {
+	var h = hamt64.New(functional, hamt64.HybridTables)
+	for i := 0; i < num; i++ {
+		h, _ = h.Put(hamt64.StringKey("key"+strconv.Itoa(i)), i)
+	}
+	return h
+}
+
+func TestRegistryStats(t *testing.T) {
+	var reg = hamtmetrics.NewRegistry()
+
+	var h64 = buildHamt64(false, 1000)
+	reg.Register64("transient", h64)
+
+	var h32 = hamt32.New(true, hamt32.SparseTables)
+	h32, _ = h32.Put(hamt32.StringKey("foo"), "bar")
+	reg.Register32("h32", h32)
+
+	var ref atomic.Value
+	reg.RegisterAtomic("atomic", &ref)
+
+	var all = reg.Stats()
+	if _, found := all["atomic"]; found {
+		t.Fatal("empty atomic.Value reported Stats")
+	}
+	if all["transient"].KeyVals != 1000 {
+		t.Fatalf("all[\"transient\"].KeyVals,%d != 1000",
+			all["transient"].KeyVals)
+	}
+	if all["h32"].KeyVals != 1 {
+		t.Fatalf("all[\"h32\"].KeyVals,%d != 1", all["h32"].KeyVals)
+	}
+
+	// the transient Hamt is modified in place, so the Registry sees it
+	h64.Put(hamt64.StringKey("one more"), 0)
+
+	var hf = buildHamt64(true, 10)
+	ref.Store(hf)
+	hf, _ = hf.Put(hamt64.StringKey("newer"), 0)
+	ref.Store(hf)
+
+	all = reg.Stats()
+	if all["transient"].KeyVals != 1001 {
+		t.Fatalf("all[\"transient\"].KeyVals,%d != 1001",
+			all["transient"].KeyVals)
+	}
+	if all["atomic"].KeyVals != 11 {
+		t.Fatalf("all[\"atomic\"].KeyVals,%d != 11", all["atomic"].KeyVals)
+	}
+
+	// a live HamtTransient is registered through its lock
+	var mu sync.Mutex
+	var live = buildHamt64(false, 5)
+	reg.RegisterFunc64("live", func() *hamt64.Stats {
+		mu.Lock()
+		defer mu.Unlock()
+		return live.QuickStats()
+	})
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			mu.Lock()
+			live.Put(hamt64.StringKey("live"+strconv.Itoa(i)), i)
+			mu.Unlock()
+		}
+	}()
+	reg.Stats()
+	<-done
+	if n := reg.Stats()["live"].KeyVals; n != 105 {
+		t.Fatalf("reg.Stats()[\"live\"].KeyVals,%d != 105", n)
+	}
+
+	reg.Unregister("h32")
+	reg.Unregister("live")
+	var names = reg.Names()
+	if strings.Join(names, ",") != "atomic,transient" {
+		t.Fatalf("reg.Names(),%v != [atomic transient]", names)
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	var reg = hamtmetrics.NewRegistry()
+	reg.Register64("h", buildHamt64(true, 100))
+	reg.PublishExpvar("hamtmetrics_test")
+
+	var v = expvar.Get("hamtmetrics_test")
+	if v == nil {
+		t.Fatal("expvar.Get(\"hamtmetrics_test\") == nil")
+	}
+
+	var decoded map[string]hamtmetrics.Stats
+	if err := json.Unmarshal([]byte(v.String()), &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(%q) => %s", v.String(), err)
+	}
+	if decoded["h"].KeyVals != 100 {
+		t.Fatalf("decoded[\"h\"].KeyVals,%d != 100", decoded["h"].KeyVals)
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	var reg = hamtmetrics.NewRegistry()
+	var h = buildHamt64(true, 5000)
+	reg.Register64(`we"ird`, h)
+
+	var rec = httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	var body = rec.Body.String()
+	var stats = h.Stats()
+
+	var expected = []string{
+		"# TYPE hamt_keyvals gauge",
+		`hamt_keyvals{hamt="we\"ird"} 5000`,
+		`hamt_collision_leafs{hamt="we\"ird"} ` +
+			strconv.Itoa(int(stats.CollisionLeafs)),
+		"# TYPE hamt_table_nentries histogram",
+		`hamt_table_nentries_count{hamt="we\"ird"} ` +
+			strconv.Itoa(int(stats.Tables)),
+		`hamt_table_depth_bucket{hamt="we\"ird",le="+Inf"} ` +
+			strconv.Itoa(int(stats.Tables)),
+		`hamt_table_depth_bucket{hamt="we\"ird",le="0"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing line %q in:\n%s", line, body)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/lleo/go-hamt"
 	"github.com/lleo/go-hamt/hamt32"
 	"github.com/lleo/go-hamt/hamt64"
+	"github.com/lleo/go-hamt/hamt64/hamttest"
 	"github.com/lleo/stringutil"
 	"github.com/pkg/errors"
 )
@@ -46,23 +47,7 @@ var StartTime = make(map[string]time.Time)
 var RunTime = make(map[string]time.Duration)
 
 func TestMain(m *testing.M) {
-	var fixedonly, sparseonly, hybrid, all bool
-	flag.BoolVar(&fixedonly, "F", false,
-		"Use fixed tables only and exclude C and H Options.")
-	flag.BoolVar(&sparseonly, "S", false,
-		"Use sparse tables only and exclude F and H Options.")
-	flag.BoolVar(&hybrid, "H", false,
-		"Use sparse tables initially and exclude F and S Options.")
-	flag.BoolVar(&all, "A", false,
-		"Run all Tests w/ Options set to FixedTables, SparseTables, and HybridTables")
-
-	var functional, transient, both bool
-	flag.BoolVar(&functional, "f", false,
-		"Run Tests against HamtFunctional struct; excludes transient option")
-	flag.BoolVar(&transient, "t", false,
-		"Run Tests against HamtFunctional struct; excludes functional option")
-	flag.BoolVar(&both, "b", false,
-		"Run Tests against both transient and functional Hamt types.")
+	var configFlags = hamttest.RegisterFlags(flag.CommandLine)
 
 	var appendLog bool
 	flag.BoolVar(&appendLog, "a", false,
@@ -74,38 +59,15 @@ func TestMain(m *testing.M) {
 
 	flag.Parse()
 
-	// If all flag set, ignore fixedonly, sparseonly, and hybrid.
-	if !all {
-
-		// only one flag may be set between fixedonly, sparseonly, and hybrid
-		if (fixedonly && (sparseonly || hybrid)) ||
-			(sparseonly && (fixedonly || hybrid)) ||
-			(hybrid && (sparseonly || fixedonly)) {
-			flag.PrintDefaults()
-			os.Exit(1)
-		}
-	}
-
-	// If no flags given, run all tests.
-	if !(all || fixedonly || sparseonly || hybrid) {
-		all = true
-	}
-
-	if !both {
-		if functional && transient {
-			flag.PrintDefaults()
-			os.Exit(1)
-		}
-	}
-
-	if !(both || functional || transient) {
-		both = true
+	var configs, err = configFlags.Configs()
+	if err != nil {
+		flag.PrintDefaults()
+		os.Exit(1)
 	}
 
 	log.SetFlags(log.Lshortfile)
 
 	var logFile *os.File
-	var err error
 	if appendLog {
 		logFile, err = os.OpenFile(logFn, os.O_CREATE|os.O_APPEND, 0666)
 		if err != nil {
@@ -129,90 +91,22 @@ func TestMain(m *testing.M) {
 
 	// execute
 	var xit int
-	if all {
-		if both {
-			Functional = false
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-
-			xit = executeAll(m)
-			if xit != 0 {
-				log.Printf("\n", RunTimes())
-				os.Exit(xit)
-			}
-
-			Hamt32 = nil
-			Hamt64 = nil
-
-			Functional = true
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-
-			xit = executeAll(m)
-		} else if functional {
-			Functional = true
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-
-			xit = executeAll(m)
-		} else if transient {
-			Functional = false
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-
-			xit = executeAll(m)
-		}
-	} else {
-		if hybrid {
-			TableOption = hamt32.HybridTables
-		} else if fixedonly {
-			TableOption = hamt32.FixedTables
-		} else /* if sparseonly */ {
-			TableOption = hamt32.SparseTables
-		}
-
-		if both {
-			Functional = false
-
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-			log.Printf("TestMain: TableOption=%s;\n",
-				hamt32.TableOptionName[TableOption])
-			fmt.Printf("TestMain: TableOption=%s;\n",
-				hamt32.TableOptionName[TableOption])
-
-			xit = m.Run()
-			if xit != 0 {
-				log.Printf("\n", RunTimes())
-				os.Exit(xit)
-			}
-
-			Hamt32 = nil
-			Hamt64 = nil
-			Functional = true
-
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-			log.Printf("TestMain: TableOption=%s;\n",
-				hamt32.TableOptionName[TableOption])
-			fmt.Printf("TestMain: TableOption=%s;\n",
-				hamt32.TableOptionName[TableOption])
-
-			xit = m.Run()
-		} else {
-			if functional {
-				Functional = true
-			} else /* if transient */ {
-				Functional = false
-			}
-
-			log.Printf("TestMain: Functional=%t;\n", Functional)
-			fmt.Printf("TestMain: Functional=%t;\n", Functional)
-			log.Printf("TestMain: TableOption=%s;\n",
-				hamt32.TableOptionName[TableOption])
-			fmt.Printf("TestMain: TableOption=%s;\n",
-				hamt32.TableOptionName[TableOption])
-			xit = m.Run()
+	for _, cfg := range configs {
+		Hamt32 = nil
+		Hamt64 = nil
+		Functional = cfg.Functional
+		TableOption = cfg.TableOption
+
+		log.Printf("TestMain: Functional=%t;\n", Functional)
+		fmt.Printf("TestMain: Functional=%t;\n", Functional)
+		log.Printf("TestMain: TableOption=%s;\n",
+			hamt32.TableOptionName[TableOption])
+		fmt.Printf("TestMain: TableOption=%s;\n",
+			hamt32.TableOptionName[TableOption])
+
+		xit = m.Run()
+		if xit != 0 {
+			break
 		}
 	}
 
@@ -220,49 +114,6 @@ func TestMain(m *testing.M) {
 	os.Exit(xit)
 }
 
-func executeAll(m *testing.M) int {
-	TableOption = hamt32.HybridTables
-
-	log.Printf("TestMain: TableOption=%s;\n",
-		hamt32.TableOptionName[TableOption])
-	fmt.Printf("TestMain: TableOption=%s;\n",
-		hamt32.TableOptionName[TableOption])
-
-	var xit = m.Run()
-	if xit != 0 {
-		log.Println("\n", RunTimes())
-		os.Exit(1)
-	}
-
-	Hamt32 = nil
-	Hamt64 = nil
-	TableOption = hamt32.FixedTables
-
-	log.Printf("TestMain: TableOption=%s;\n",
-		hamt32.TableOptionName[TableOption])
-	fmt.Printf("TestMain: TableOption=%s;\n",
-		hamt32.TableOptionName[TableOption])
-
-	xit = m.Run()
-	if xit != 0 {
-		log.Println("\n", RunTimes())
-		os.Exit(1)
-	}
-
-	Hamt32 = nil
-	Hamt64 = nil
-	TableOption = hamt32.SparseTables
-
-	log.Printf("TestMain: TableOption=%s;\n",
-		hamt32.TableOptionName[TableOption])
-	fmt.Printf("TestMain: TableOption=%s;\n",
-		hamt32.TableOptionName[TableOption])
-
-	xit = m.Run()
-
-	return xit
-}
-
 func buildKeyVals(prefix string, num int) []KeyVal {
 	var name = fmt.Sprintf("%s-buildKeyVals-%d", prefix, num)
 	StartTime[name] = time.Now()
@@ -0,0 +1,190 @@
+/*
+Package orderedmap implements an OrderedMap, a persistent map which Range()s
+over its key/value pairs in the order the keys were first Put().
+
+An OrderedMap pairs a hamt64.Hamt, mapping each key to its sequence number,
+with a vector.Vector, mapping each sequence number to its key/value pair. Put()
+of a new key appends to the Vector; Put() of an existing key replaces the pair
+at the key's sequence number, so the key keeps its position; Del() replaces the
+pair with a tombstone. Every one of those is O(log n) and, in functional mode,
+shares all but the modified paths of both tries with the original.
+
+Tombstones at the end of the Vector are popped off. When the tombstones
+outnumber the live pairs the sequence is compacted, which renumbers every key
+and is O(n). The compacted OrderedMap is a new one; the one Del() was called
+on keeps its tombstones. So the O(n) compaction is only amortized, to O(1) per
+Del(), while each Del() is called on the OrderedMap the previous one returned,
+as a single thread of updates does. Calling Del() again and again on the same
+functional OrderedMap, one that is a single Del() short of compaction, costs
+O(n) every time.
+*/
+package orderedmap
+
+import (
+	"github.com/lleo/go-hamt/hamt64"
+	"github.com/lleo/go-hamt/vector"
+)
+
+// OrderedMap is an insertion ordered map. Like the Hamt, an OrderedMap is
+// either functional, where Put() and Del() return a new OrderedMap and leave
+// the original unaltered, or transient, where they modify the OrderedMap in
+// place and return it.
+type OrderedMap struct {
+	index  hamt64.Hamt   // key -> sequence number (uint)
+	order  vector.Vector // sequence number -> hamt64.KeyVal, or nil
+	tblOpt int
+}
+
+// New constructs an empty OrderedMap.
+//
+// When the functional argument is true the OrderedMap is functional, otherwise
+// it is transient. The tblOpt argument is the hamt64 table option,
+// hamt64.HybridTables, hamt64.SparseTables, xor hamt64.FixedTables.
+func New(functional bool, tblOpt int) *OrderedMap {
+	var m = new(OrderedMap)
+	m.index = hamt64.New(functional, tblOpt)
+	m.order = vector.New(functional)
+	m.tblOpt = tblOpt
+	return m
+}
+
+// IsFunctional returns true if the OrderedMap is functional, and false if it
+// is transient.
+func (m *OrderedMap) IsFunctional() bool {
+	var _, isFunctional = m.index.(*hamt64.HamtFunctional)
+	return isFunctional
+}
+
+// IsEmpty returns true if the OrderedMap has no entries.
+func (m *OrderedMap) IsEmpty() bool {
+	return m.index.IsEmpty()
+}
+
+// Nentries returns the number of (key,value) pairs in the OrderedMap.
+func (m *OrderedMap) Nentries() uint {
+	return m.index.Nentries()
+}
+
+// Get retrieves the value related to the key. It also returns a bool to
+// indicate the value was found.
+func (m *OrderedMap) Get(key hamt64.KeyI) (interface{}, bool) {
+	var seq, found = m.index.Get(key)
+	if !found {
+		return nil, false
+	}
+	var kv, _ = m.order.Get(seq.(uint))
+	return kv.(hamt64.KeyVal).Val, true
+}
+
+// Put stores the (key,value) pair. A new key goes at the end of the order; an
+// existing key keeps its position. It returns the resulting OrderedMap and a
+// bool indicating if a new pair was added (true) or the value was replaced
+// (false).
+func (m *OrderedMap) Put(key hamt64.KeyI, val interface{}) (*OrderedMap, bool) {
+	var nm = m.modifiable()
+
+	var seq, found = m.index.Get(key)
+	if found {
+		nm.order, _ = m.order.Set(seq.(uint), hamt64.KeyVal{Key: key, Val: val})
+		return nm, false
+	}
+
+	nm.index, _ = m.index.Put(key, m.order.Len())
+	nm.order = m.order.Append(hamt64.KeyVal{Key: key, Val: val})
+
+	return nm, true
+}
+
+// Del removes the key. It returns the resulting OrderedMap, the value that
+// was related to the key, and a bool indicating if the key was found.
+//
+// If the key was not found the original OrderedMap is returned.
+//
+// Del is O(log n), but one Del in about every n/2 compacts the OrderedMap,
+// which is O(n); see the package documentation for when that is amortized.
+func (m *OrderedMap) Del(key hamt64.KeyI) (*OrderedMap, interface{}, bool) {
+	var seq, found = m.index.Get(key)
+	if !found {
+		return m, nil, false
+	}
+
+	var kv, _ = m.order.Get(seq.(uint))
+
+	var nm = m.modifiable()
+	nm.index, _, _ = m.index.Del(key)
+	nm.order, _ = m.order.Set(seq.(uint), nil)
+
+	// pop the tombstones off the end
+	for !nm.order.IsEmpty() {
+		var last, _ = nm.order.Get(nm.order.Len() - 1)
+		if last != nil {
+			break
+		}
+		nm.order, _, _ = nm.order.Pop()
+	}
+
+	if nm.order.Len() > 2*nm.index.Nentries()+vector.IndexLimit {
+		nm.compact()
+	}
+
+	return nm, kv.(hamt64.KeyVal).Val, true
+}
+
+// modifiable returns a copy of a functional OrderedMap, or the transient
+// OrderedMap itself.
+func (m *OrderedMap) modifiable() *OrderedMap {
+	if !m.IsFunctional() {
+		return m
+	}
+	var nm = new(OrderedMap)
+	*nm = *m
+	return nm
+}
+
+// compact rebuilds the order without the tombstones, and the index with the
+// new sequence numbers. The new tries are built as transients, then converted
+// to the mode of the OrderedMap.
+func (m *OrderedMap) compact() {
+	var functional = m.IsFunctional()
+
+	var index = hamt64.New(false, m.tblOpt)
+	var order = vector.New(false)
+	m.order.Range(func(_ uint, kv interface{}) bool {
+		if kv != nil {
+			index.Put(kv.(hamt64.KeyVal).Key, order.Len())
+			order.Append(kv)
+		}
+		return true
+	})
+
+	if functional {
+		m.index = index.ToFunctional()
+		m.order = order.ToFunctional()
+	} else {
+		m.index = index
+		m.order = order
+	}
+}
+
+// Range executes the given function for every (key,value) pair in the
+// OrderedMap, in the order the keys were first Put(). The iteration stops if
+// fn returns false.
+func (m *OrderedMap) Range(fn func(hamt64.KeyI, interface{}) bool) {
+	m.order.Range(func(_ uint, v interface{}) bool {
+		if v == nil {
+			return true
+		}
+		var kv = v.(hamt64.KeyVal)
+		return fn(kv.Key, kv.Val)
+	})
+}
+
+// Keys returns the keys of the OrderedMap in order.
+func (m *OrderedMap) Keys() []hamt64.KeyI {
+	var keys = make([]hamt64.KeyI, 0, m.Nentries())
+	m.Range(func(key hamt64.KeyI, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
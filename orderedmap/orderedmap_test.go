@@ -0,0 +1,102 @@
+package orderedmap_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/lleo/go-hamt/hamt64"
+	"github.com/lleo/go-hamt/orderedmap"
+)
+
+func key(i int) hamt64.KeyI {
+	return hamt64.StringKey("key" + strconv.Itoa(i))
+}
+
+func checkOrder(t *testing.T, name string, m *orderedmap.OrderedMap,
+	order []int) {
+	if m.Nentries() != uint(len(order)) {
+		t.Fatalf("%s: m.Nentries(),%d != %d", name, m.Nentries(), len(order))
+	}
+
+	var n int
+	m.Range(func(k hamt64.KeyI, v interface{}) bool {
+		if !k.Equals(key(order[n])) || v != order[n]*10 {
+			t.Fatalf("%s: m.Range() entry %d is %s:%v; expected %s:%d",
+				name, n, k, v, key(order[n]), order[n]*10)
+		}
+		n++
+		return true
+	})
+	if n != len(order) {
+		t.Fatalf("%s: m.Range() visited %d entries; expected %d",
+			name, n, len(order))
+	}
+
+	for _, i := range order {
+		if v, found := m.Get(key(i)); !found || v != i*10 {
+			t.Fatalf("%s: m.Get(%s) => %v, %t", name, key(i), v, found)
+		}
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	for _, functional := range []bool{true, false} {
+		var name = "TestOrderedMap:transient"
+		if functional {
+			name = "TestOrderedMap:functional"
+		}
+
+		// insert in descending order, so hash order is not insertion order
+		var m = orderedmap.New(functional, hamt64.HybridTables)
+		var order []int
+		for i := 999; i >= 0; i-- {
+			var added bool
+			m, added = m.Put(key(i), i)
+			if !added {
+				t.Fatalf("%s: m.Put(%s) did not add", name, key(i))
+			}
+			order = append(order, i)
+		}
+
+		// replacing a value keeps the position
+		for i := range order {
+			var added bool
+			m, added = m.Put(key(i), i*10)
+			if added {
+				t.Fatalf("%s: m.Put(%s) added an existing key", name, key(i))
+			}
+		}
+		checkOrder(t, name+":Put", m, order)
+
+		var orig = m
+
+		// delete most of the keys to force a compaction, including the last
+		var kept []int
+		for n, i := range order {
+			if n%10 != 3 {
+				var val interface{}
+				var found bool
+				m, val, found = m.Del(key(i))
+				if !found || val != i*10 {
+					t.Fatalf("%s: m.Del(%s) => %v, %t",
+						name, key(i), val, found)
+				}
+			} else {
+				kept = append(kept, i)
+			}
+		}
+		checkOrder(t, name+":Del", m, kept)
+
+		if _, _, found := m.Del(key(0)); found {
+			t.Fatalf("%s: m.Del(%s) found a deleted key", name, key(0))
+		}
+
+		// re-adding a deleted key puts it at the end
+		m, _ = m.Put(key(0), 0)
+		checkOrder(t, name+":re-Put", m, append(kept, 0))
+
+		if functional {
+			checkOrder(t, name+":persistent", orig, order)
+		}
+	}
+}
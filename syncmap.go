@@ -0,0 +1,235 @@
+package hamt
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lleo/go-hamt/hamt64"
+)
+
+// SyncMap has the same method set as sync.Map. It is backed by a
+// hamt64.HamtFunctional which is atomically published after every
+// modification. Loads never block, modifications are serialized, and Range
+// iterates over a point-in-time snapshot; something sync.Map can not do.
+//
+// The keys must implement hamt64.KeyI (eg. hamt64.StringKey), or be of a
+// string, []byte, or integer kind; any other key causes a panic, just as an
+// unhashable key does for sync.Map. So SyncMap can replace a sync.Map only
+// where its keys are of those kinds. As with sync.Map, keys of different types
+// are different keys, even if their values are equal.
+//
+// The zero SyncMap is empty and ready for use. A SyncMap must not be copied
+// after first use.
+type SyncMap struct {
+	mu   sync.Mutex
+	hamt atomic.Value // hamt64.Hamt
+}
+
+// emptySyncMap is the Hamt of a SyncMap nothing has been stored in yet. It is
+// a HamtFunctional, so every SyncMap can share it.
+var emptySyncMap = hamt64.New(true, HybridTables)
+
+// nativeKey is the hamt64.KeyI a SyncMap uses for a key of a string, []byte,
+// or integer kind. It embeds the hamt64 key of the same kind, and keeps the
+// key as given, of its own type, for Range() to pass back.
+type nativeKey struct {
+	hamt64.KeyI
+	key interface{}
+}
+
+func (k nativeKey) Equals(other hamt64.KeyI) bool {
+	var o, ok = other.(nativeKey)
+	return ok && reflect.TypeOf(k.key) == reflect.TypeOf(o.key) &&
+		k.KeyI.Equals(o.KeyI)
+}
+
+// syncMapKey returns the hamt64.KeyI for a SyncMap key. A []byte key is
+// copied if it is to be stored, so modifying the slice does not modify the
+// key.
+func syncMapKey(key interface{}, store bool) hamt64.KeyI {
+	if k, ok := key.(hamt64.KeyI); ok {
+		return k
+	}
+
+	var v = reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.String:
+		return nativeKey{hamt64.StringKey(v.String()), key}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return nativeKey{hamt64.Int64Key(v.Int()), key}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return nativeKey{hamt64.Uint64Key(v.Uint()), key}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		if store {
+			var c = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			reflect.Copy(c, v)
+			v, key = c, c.Interface()
+		}
+		return nativeKey{hamt64.ByteSliceKey(v.Bytes()), key}
+	}
+
+	panic(fmt.Sprintf("hamt.SyncMap: key of type %T is not a hamt64.KeyI "+
+		"nor of a string, []byte, or integer kind", key))
+}
+
+// Snapshot returns the current hamt64.HamtFunctional underlying the SyncMap.
+// It is immutable, so it is safe to use while the SyncMap is modified.
+//
+// The keys of the Snapshot are the hamt64.KeyIs the SyncMap made of the keys
+// it was given; only the keys that already were hamt64.KeyIs are the same.
+func (m *SyncMap) Snapshot() hamt64.Hamt {
+	var h, _ = m.hamt.Load().(hamt64.Hamt)
+	if h == nil {
+		return emptySyncMap
+	}
+	return h
+}
+
+// update calls fn, with the write lock held, on the current Hamt; then
+// publishes the Hamt fn returns, if it is not nil.
+func (m *SyncMap) update(fn func(h hamt64.Hamt) hamt64.Hamt) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if nh := fn(m.Snapshot()); nh != nil {
+		m.hamt.Store(nh)
+	}
+}
+
+// Load returns the value stored in the map for a key, or nil if no value is
+// present. The ok result indicates whether value was found in the map.
+func (m *SyncMap) Load(key interface{}) (value interface{}, ok bool) {
+	var h, _ = m.hamt.Load().(hamt64.Hamt)
+	if h == nil {
+		return nil, false
+	}
+	return h.Get(syncMapKey(key, false))
+}
+
+// Store sets the value for a key.
+func (m *SyncMap) Store(key, value interface{}) {
+	m.Swap(key, value)
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored.
+func (m *SyncMap) LoadOrStore(
+	key, value interface{},
+) (actual interface{}, loaded bool) {
+	var k = syncMapKey(key, true)
+	if actual, loaded = m.Snapshot().Get(k); loaded {
+		return actual, loaded
+	}
+
+	m.update(func(h hamt64.Hamt) hamt64.Hamt {
+		if actual, loaded = h.Get(k); loaded {
+			return nil
+		}
+		actual = value
+		var nh, _ = h.Put(k, value)
+		return nh
+	})
+	return actual, loaded
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if
+// any. The loaded result reports whether the key was present.
+func (m *SyncMap) LoadAndDelete(
+	key interface{},
+) (value interface{}, loaded bool) {
+	var k = syncMapKey(key, false)
+	if _, found := m.Snapshot().Get(k); !found {
+		return nil, false
+	}
+
+	m.update(func(h hamt64.Hamt) hamt64.Hamt {
+		var nh hamt64.Hamt
+		if nh, value, loaded = h.Del(k); !loaded {
+			return nil
+		}
+		return nh
+	})
+	return value, loaded
+}
+
+// Delete deletes the value for a key.
+func (m *SyncMap) Delete(key interface{}) {
+	m.LoadAndDelete(key)
+}
+
+// Swap swaps the value for a key and returns the previous value if any. The
+// loaded result reports whether the key was present.
+func (m *SyncMap) Swap(
+	key, value interface{},
+) (previous interface{}, loaded bool) {
+	var k = syncMapKey(key, true)
+	m.update(func(h hamt64.Hamt) hamt64.Hamt {
+		previous, loaded = h.Get(k)
+		var nh, _ = h.Put(k, value)
+		return nh
+	})
+	return previous, loaded
+}
+
+// CompareAndSwap swaps the old and new values for key if the value stored in
+// the map is equal to old. The old value must be of a comparable type.
+func (m *SyncMap) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	var k = syncMapKey(key, true)
+	if val, found := m.Snapshot().Get(k); !found || val != old {
+		return false
+	}
+
+	m.update(func(h hamt64.Hamt) hamt64.Hamt {
+		if val, found := h.Get(k); !found || val != old {
+			return nil
+		}
+		swapped = true
+		var nh, _ = h.Put(k, new)
+		return nh
+	})
+	return swapped
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old. The
+// old value must be of a comparable type.
+//
+// If there is no current value for key in the map, CompareAndDelete returns
+// false (even if the old value is the nil interface value).
+func (m *SyncMap) CompareAndDelete(key, old interface{}) (deleted bool) {
+	var k = syncMapKey(key, false)
+	if val, found := m.Snapshot().Get(k); !found || val != old {
+		return false
+	}
+
+	m.update(func(h hamt64.Hamt) hamt64.Hamt {
+		if val, found := h.Get(k); !found || val != old {
+			return nil
+		}
+		deleted = true
+		var nh, _, _ = h.Del(k)
+		return nh
+	})
+	return deleted
+}
+
+// Range calls f sequentially for each key and value present in the map. If f
+// returns false, range stops the iteration.
+//
+// Unlike sync.Map, Range iterates over a consistent snapshot of the map; it
+// sees no Store or Delete made after Range was called. f may modify the map.
+func (m *SyncMap) Range(f func(key, value interface{}) bool) {
+	m.Snapshot().Range(func(k hamt64.KeyI, v interface{}) bool {
+		if nk, isNative := k.(nativeKey); isNative {
+			return f(nk.key, v)
+		}
+		return f(k, v)
+	})
+}
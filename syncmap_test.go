@@ -0,0 +1,151 @@
+package hamt_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/lleo/go-hamt"
+	"github.com/lleo/go-hamt/hamt64"
+)
+
+func TestSyncMap(t *testing.T) {
+	var name = "TestSyncMap"
+
+	var m hamt.SyncMap
+	if _, ok := m.Load(hamt64.StringKey("missing")); ok {
+		t.Fatalf("%s: the zero SyncMap is not empty", name)
+	}
+	var allocs = testing.AllocsPerRun(100, func() {
+		m.Load(hamt64.StringKey("missing"))
+	})
+	if allocs != 0 {
+		t.Fatalf("%s: Load() on the zero SyncMap allocated %v times",
+			name, allocs)
+	}
+
+	// 8 goroutines Store 1000 keys each
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 8000; i += workers {
+				m.Store(hamt64.StringKey(KVS[i].Key), KVS[i].Val)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var snap = m.Snapshot()
+	if snap.Nentries() != 8000 {
+		t.Fatalf("%s: snap.Nentries(),%d != 8000", name, snap.Nentries())
+	}
+
+	var k0, k1 = hamt64.StringKey(KVS[0].Key), hamt64.StringKey(KVS[1].Key)
+	var kNew = hamt64.StringKey(KVS[8000].Key)
+
+	if actual, loaded := m.LoadOrStore(k0, -1); !loaded || actual != 0 {
+		t.Fatalf("%s: m.LoadOrStore(k0) => %v, %t", name, actual, loaded)
+	}
+	if actual, loaded := m.LoadOrStore(kNew, -1); loaded || actual != -1 {
+		t.Fatalf("%s: m.LoadOrStore(kNew) => %v, %t", name, actual, loaded)
+	}
+	if prev, loaded := m.Swap(kNew, 8000); !loaded || prev != -1 {
+		t.Fatalf("%s: m.Swap(kNew) => %v, %t", name, prev, loaded)
+	}
+	if m.CompareAndSwap(k0, 1, 100) {
+		t.Fatalf("%s: m.CompareAndSwap(k0, 1, 100) swapped", name)
+	}
+	if !m.CompareAndSwap(k0, 0, 100) {
+		t.Fatalf("%s: m.CompareAndSwap(k0, 0, 100) did not swap", name)
+	}
+	if m.CompareAndDelete(k1, 0) {
+		t.Fatalf("%s: m.CompareAndDelete(k1, 0) deleted", name)
+	}
+	if !m.CompareAndDelete(k1, 1) {
+		t.Fatalf("%s: m.CompareAndDelete(k1, 1) did not delete", name)
+	}
+	if val, loaded := m.LoadAndDelete(k1); loaded {
+		t.Fatalf("%s: m.LoadAndDelete(k1) => %v, %t", name, val, loaded)
+	}
+	m.Delete(kNew)
+	if _, ok := m.Load(kNew); ok {
+		t.Fatalf("%s: m.Load(kNew) found a deleted key", name)
+	}
+
+	// the earlier snapshot is unchanged
+	if val, found := snap.Get(k0); !found || val != 0 {
+		t.Fatalf("%s: snap.Get(k0) => %v, %t", name, val, found)
+	}
+
+	// Range sees a snapshot, so deleting as it goes is safe
+	var n int
+	m.Range(func(k, v interface{}) bool {
+		m.Delete(k)
+		n++
+		return true
+	})
+	if n != 7999 {
+		t.Fatalf("%s: m.Range() visited %d entries; expected 7999", name, n)
+	}
+	if !m.Snapshot().IsEmpty() {
+		t.Fatalf("%s: m is not empty after deleting every key", name)
+	}
+}
+
+func TestSyncMapNativeKeys(t *testing.T) {
+	var name = "TestSyncMapNativeKeys"
+
+	type myInt int
+	var b = []byte("bytes")
+	var keys = []interface{}{"string", b, 1, int64(1), myInt(1), uint8(1)}
+
+	var m hamt.SyncMap
+	for i, key := range keys {
+		m.Store(key, i)
+	}
+	b[0] = 'B' // the stored key is a copy
+
+	// keys of different types are different keys, even with equal values
+	for i, key := range keys {
+		if i == 1 {
+			key = []byte("bytes")
+		}
+		if val, ok := m.Load(key); !ok || val != i {
+			t.Fatalf("%s: m.Load(%T(%v)) => %v, %t", name, key, key, val, ok)
+		}
+	}
+	if _, ok := m.Load(hamt64.StringKey("string")); ok {
+		t.Fatalf("%s: m.Load(StringKey) found the string key", name)
+	}
+
+	// Range passes back the keys as they were given
+	var seen = make(map[string]bool)
+	m.Range(func(key, val interface{}) bool {
+		var want = keys[val.(int)]
+		if val.(int) == 1 {
+			want = "bytes"
+			key = string(key.([]byte))
+		}
+		if key != want {
+			t.Fatalf("%s: m.Range() key %T(%v); expected %T(%v)",
+				name, key, key, want, want)
+		}
+		seen[fmt.Sprintf("%T", key)] = true
+		return true
+	})
+	if len(seen) != len(keys)-1 {
+		t.Fatalf("%s: m.Range() saw key types %v", name, seen)
+	}
+
+	var panicked bool
+	func() {
+		defer func() { panicked = recover() != nil }()
+		m.Store(1.5, "float")
+	}()
+	if !panicked {
+		t.Fatalf("%s: m.Store() of a float64 key did not panic", name)
+	}
+}
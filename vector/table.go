@@ -0,0 +1,137 @@
+package vector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// table is an interior node of the Vector. At shift == 0 the slots hold the
+// values of the Vector; at every other shift they hold *table's. The slots
+// past the end of the Vector are always nil.
+type table struct {
+	nodes [IndexLimit]interface{}
+}
+
+func (t *table) copy() *table {
+	var nt = new(table)
+	*nt = *t
+	return nt
+}
+
+func (t *table) deepCopy(shift uint) *table {
+	var nt = new(table)
+	if shift == 0 {
+		nt.nodes = t.nodes
+		return nt
+	}
+	for i, n := range t.nodes {
+		if child, isTable := n.(*table); isTable {
+			nt.nodes[i] = child.deepCopy(shift - NumIndexBits)
+		}
+	}
+	return nt
+}
+
+// set stores val at index i in the subtrie rooted at t, creating the tables
+// along the way if t (or any table below it) is nil. When copy is true the
+// tables along the path are copied rather than modified. It returns the new
+// (or modified) root of the subtrie.
+func (t *table) set(shift, i uint, val interface{}, copy bool) *table {
+	var nt = t
+	if nt == nil {
+		nt = new(table)
+	} else if copy {
+		nt = t.copy()
+	}
+
+	var idx = (i >> shift) & maxIndex
+	if shift == 0 {
+		nt.nodes[idx] = val
+	} else {
+		var child, _ = nt.nodes[idx].(*table)
+		nt.nodes[idx] = child.set(shift-NumIndexBits, i, val, copy)
+	}
+
+	return nt
+}
+
+// pop clears index i, which must be the last index of the Vector, in the
+// subtrie rooted at t. It returns the new (or modified) root of the subtrie,
+// or nil if the subtrie is now empty.
+func (t *table) pop(shift, i uint, copy bool) *table {
+	if i&(1<<(shift+NumIndexBits)-1) == 0 {
+		// i is the first, and so the only, index left in this subtrie
+		return nil
+	}
+
+	var idx = (i >> shift) & maxIndex
+
+	var nt = t
+	if copy {
+		nt = t.copy()
+	}
+
+	if shift == 0 {
+		nt.nodes[idx] = nil
+	} else {
+		var child = nt.nodes[idx].(*table).pop(shift-NumIndexBits, i, copy)
+		if child == nil {
+			nt.nodes[idx] = nil
+		} else {
+			nt.nodes[idx] = child
+		}
+	}
+
+	return nt
+}
+
+// visit calls fn for every index, below length, in the subtrie rooted at t in
+// order. The indexes in the subtrie all start with base. It returns false if
+// fn did.
+func (t *table) visit(
+	shift, base, length uint,
+	fn func(uint, interface{}) bool,
+) bool {
+	for idx, n := range t.nodes {
+		var i = base | uint(idx)<<shift
+		if i >= length {
+			return true
+		}
+		if shift == 0 {
+			if !fn(i, n) {
+				return false
+			}
+		} else if !n.(*table).visit(shift-NumIndexBits, i, length, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *table) String() string {
+	var n int
+	for _, node := range t.nodes {
+		if node != nil {
+			n++
+		}
+	}
+	return fmt.Sprintf("table{nentries: %d}", n)
+}
+
+func (t *table) LongString(indent string, shift uint) string {
+	var strs = make([]string, 0, IndexLimit+2)
+	strs = append(strs, indent+t.String())
+	for idx, n := range t.nodes {
+		if n == nil {
+			continue
+		}
+		if shift == 0 {
+			strs = append(strs, fmt.Sprintf("%s  [%d]: %v", indent, idx, n))
+		} else {
+			strs = append(strs, fmt.Sprintf("%s  [%d]:", indent, idx))
+			strs = append(strs,
+				n.(*table).LongString(indent+"    ", shift-NumIndexBits))
+		}
+	}
+	return strings.Join(strs, "\n")
+}
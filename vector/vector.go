@@ -0,0 +1,60 @@
+/*
+Package vector implements a persistent Vector, an indexed sequence of values,
+as a bit-partitioned trie. It is the sibling of the hamt64 and hamt32 packages;
+where a Hamt indexes its tables with NumIndexBits wide parts of the HashVal of
+a key, a Vector indexes its tables with NumIndexBits wide parts of the index of
+a value. The tables use the same layout as the hamt64 fixedTable, an array of
+IndexLimit slots.
+
+Like the Hamt, the Vector is implemented with two code bases, which both
+implement the vector.Vector interface: the transient replace in place code and
+the functional copy on write code. VectorFunctional and VectorTransient are
+identical data structures; they only have unique names so we can hang the
+different code implementations off them.
+
+Get() and Set() are O(log32 n). Append() and Pop() only work at the end of the
+Vector and are also O(log32 n).
+*/
+package vector
+
+import "github.com/lleo/go-hamt/hamt64"
+
+// NumIndexBits is the number of bits of an index used to select a slot in
+// each table of the Vector; the same value the hamt64 package uses.
+const NumIndexBits uint = hamt64.NumIndexBits
+
+// IndexLimit is the number of slots in each table of the Vector.
+const IndexLimit = 1 << NumIndexBits
+
+// maxIndex is the maximum slot index. It is also the mask for the part of an
+// index used at each level of the trie.
+const maxIndex = IndexLimit - 1
+
+// Vector defines the interface that both the VectorFunctional and
+// VectorTransient data structures must (and do) implement.
+type Vector interface {
+	IsEmpty() bool
+	Len() uint
+	ToFunctional() Vector
+	ToTransient() Vector
+	DeepCopy() Vector
+	Get(uint) (interface{}, bool)
+	Set(uint, interface{}) (Vector, bool)
+	Append(...interface{}) Vector
+	Pop() (Vector, interface{}, bool)
+	Slice(uint, uint) Vector
+	Range(func(uint, interface{}) bool)
+	String() string
+}
+
+// New constructs a datastucture that implements the Vector interface.
+//
+// When the functional argument is true it implements a VectorFunctional data
+// structure. When the functional argument is false it implements a
+// VectorTransient data structure.
+func New(functional bool) Vector {
+	if functional {
+		return NewFunctional()
+	}
+	return NewTransient()
+}
@@ -0,0 +1,150 @@
+package vector
+
+import "fmt"
+
+// vectorBase is the data structure shared by VectorFunctional and
+// VectorTransient. The trie is (shift / NumIndexBits) + 1 tables deep; the
+// root table is indexed with bits [shift, shift+NumIndexBits) of an index,
+// and the tables holding the values with bits [0, NumIndexBits).
+type vectorBase struct {
+	root   *table
+	shift  uint
+	length uint
+}
+
+// IsEmpty simply returns if the Vector has no entries.
+func (v *vectorBase) IsEmpty() bool {
+	return v.length == 0
+}
+
+// Len returns the number of values in the Vector.
+func (v *vectorBase) Len() uint {
+	return v.length
+}
+
+// Get retrieves the value at index i. It also returns a bool which is false
+// if i is out of range.
+func (v *vectorBase) Get(i uint) (interface{}, bool) {
+	if i >= v.length {
+		return nil, false
+	}
+
+	var t = v.root
+	for shift := v.shift; shift > 0; shift -= NumIndexBits {
+		t = t.nodes[(i>>shift)&maxIndex].(*table)
+	}
+
+	return t.nodes[i&maxIndex], true
+}
+
+// set replaces the value at index i, which must be in range.
+func (v *vectorBase) set(i uint, val interface{}, copy bool) {
+	v.root = v.root.set(v.shift, i, val, copy)
+}
+
+// append adds val to the end of the Vector, adding a new root table if the
+// trie is full.
+func (v *vectorBase) append(val interface{}, copy bool) {
+	if v.root != nil && v.length == IndexLimit<<v.shift {
+		var nr = new(table)
+		nr.nodes[0] = v.root
+		v.root = nr
+		v.shift += NumIndexBits
+	}
+	v.root = v.root.set(v.shift, v.length, val, copy)
+	v.length++
+}
+
+// pop removes the last value of the Vector, which must not be empty, and
+// returns it. If the root table is left with a single child, that child
+// becomes the root.
+func (v *vectorBase) pop(copy bool) interface{} {
+	var last = v.length - 1
+	var val, _ = v.Get(last)
+
+	v.root = v.root.pop(v.shift, last, copy)
+	v.length--
+
+	if v.root == nil {
+		v.shift = 0
+	}
+	for v.shift > 0 && v.root.nodes[1] == nil {
+		v.root = v.root.nodes[0].(*table)
+		v.shift -= NumIndexBits
+	}
+
+	return val
+}
+
+// slice cuts the Vector down to the values [from, to).
+//
+// If from is 0 and only a few values are cut off the end, they are popped;
+// otherwise a new trie is built from the remaining values.
+func (v *vectorBase) slice(from, to uint, copy bool) {
+	if from > to || to > v.length {
+		panic(fmt.Sprintf("vector: slice bounds out of range [%d:%d] "+
+			"with length %d", from, to, v.length))
+	}
+
+	if from == 0 && v.length-to <= to {
+		for v.length > to {
+			v.pop(copy)
+		}
+		return
+	}
+
+	var nv = new(vectorBase)
+	v.Range(func(i uint, val interface{}) bool {
+		if i >= to {
+			return false
+		}
+		if i >= from {
+			nv.append(val, false)
+		}
+		return true
+	})
+
+	*v = *nv
+}
+
+// deepCopy copies the vectorBase and every table it contains.
+func (v *vectorBase) deepCopy() vectorBase {
+	var nv = *v
+	if v.root != nil {
+		nv.root = v.root.deepCopy(v.shift)
+	}
+	return nv
+}
+
+// Range executes the given function for every value in the Vector in index
+// order. The iteration stops if fn returns false.
+func (v *vectorBase) Range(fn func(uint, interface{}) bool) {
+	if v.root != nil {
+		v.root.visit(v.shift, 0, v.length, fn)
+	}
+}
+
+// String returns a string representation of the vectorBase data structure.
+func (v *vectorBase) String() string {
+	return fmt.Sprintf("vectorBase{ length: %d, depth: %d }",
+		v.length, v.depth())
+}
+
+// LongString returns a complete recusive listing of the entire vectorBase
+// data structure.
+func (v *vectorBase) LongString(indent string) string {
+	var str = indent + fmt.Sprintf("vectorBase{ length: %d, root:\n", v.length)
+	if v.root != nil {
+		str += v.root.LongString(indent, v.shift) + "\n"
+	}
+	str += indent + "} //vectorBase"
+	return str
+}
+
+// depth is the number of tables from the root to a value.
+func (v *vectorBase) depth() uint {
+	if v.root == nil {
+		return 0
+	}
+	return v.shift/NumIndexBits + 1
+}
@@ -0,0 +1,126 @@
+package vector
+
+// VectorFunctional is the data structure which the Functional Vector methods
+// are called upon. It is identical to the VectorTransient data structure; it
+// is its own type so that the methods it calls are the functional versions of
+// the Vector interface.
+//
+// The functional versions implement a copy-on-write implementation of Set(),
+// Append(), Pop(), and Slice(). The original VectorFunctional isn't modified;
+// they return a modified copy which shares every table not on the path to the
+// modified index. So sharing this data structure between threads is safe.
+type VectorFunctional struct {
+	vectorBase
+}
+
+// NewFunctional constructs a new, empty, VectorFunctional data structure.
+func NewFunctional() *VectorFunctional {
+	return new(VectorFunctional)
+}
+
+// IsEmpty simply returns if the VectorFunctional data structure has no
+// entries.
+func (v *VectorFunctional) IsEmpty() bool {
+	return v.vectorBase.IsEmpty()
+}
+
+// Len returns the number of values in the VectorFunctional data structure.
+func (v *VectorFunctional) Len() uint {
+	return v.vectorBase.Len()
+}
+
+// ToFunctional does nothing to a VectorFunctional pointer. This method is
+// only here for conformance with the Vector interface.
+func (v *VectorFunctional) ToFunctional() Vector {
+	return v
+}
+
+// ToTransient just recasts the VectorFunctional pointer to a VectorTransient
+// underneath the Vector interface.
+//
+// If you want a copy of the VectorFunctional data structure over to a
+// completely independent VectorTransient data structure, you should first do a
+// DeepCopy followed by a ToTransient call.
+func (v *VectorFunctional) ToTransient() Vector {
+	return (*VectorTransient)(v)
+}
+
+// DeepCopy copies the VectorFunctional data structure and every table it
+// contains recursively.
+func (v *VectorFunctional) DeepCopy() Vector {
+	var nv = new(VectorFunctional)
+	nv.vectorBase = v.vectorBase.deepCopy()
+	return nv
+}
+
+// Get retrieves the value at index i. It also returns a bool which is false if
+// i is out of range.
+func (v *VectorFunctional) Get(i uint) (interface{}, bool) {
+	return v.vectorBase.Get(i)
+}
+
+// Set replaces the value at index i. It returns a new VectorFunctional and
+// true, or the original VectorFunctional and false if i is out of range.
+func (v *VectorFunctional) Set(i uint, val interface{}) (Vector, bool) {
+	if i >= v.length {
+		return v, false
+	}
+
+	var nv = new(VectorFunctional)
+	*nv = *v
+	nv.set(i, val, true)
+
+	return nv, true
+}
+
+// Append returns a new VectorFunctional with the values added to the end.
+func (v *VectorFunctional) Append(vals ...interface{}) Vector {
+	var nv = new(VectorFunctional)
+	*nv = *v
+	for _, val := range vals {
+		nv.append(val, true)
+	}
+	return nv
+}
+
+// Pop removes the last value. It returns a new VectorFunctional, the value
+// removed, and true; or the original VectorFunctional, nil, and false if the
+// VectorFunctional is empty.
+func (v *VectorFunctional) Pop() (Vector, interface{}, bool) {
+	if v.IsEmpty() {
+		return v, nil, false
+	}
+
+	var nv = new(VectorFunctional)
+	*nv = *v
+	var val = nv.pop(true)
+
+	return nv, val, true
+}
+
+// Slice returns a new VectorFunctional of the values [from, to). Like slicing
+// a Go slice, it panics if from > to or to > Len().
+func (v *VectorFunctional) Slice(from, to uint) Vector {
+	var nv = new(VectorFunctional)
+	*nv = *v
+	nv.slice(from, to, true)
+	return nv
+}
+
+// Range executes the given function for every value in the VectorFunctional
+// in index order. The iteration stops if fn returns false.
+func (v *VectorFunctional) Range(fn func(uint, interface{}) bool) {
+	v.vectorBase.Range(fn)
+}
+
+// String returns a simple string representation of the VectorFunctional data
+// structure.
+func (v *VectorFunctional) String() string {
+	return "VectorFunctional{" + v.vectorBase.String() + "}"
+}
+
+// LongString returns a complete recusive listing of the entire
+// VectorFunctional data structure.
+func (v *VectorFunctional) LongString(indent string) string {
+	return "VectorFunctional{\n" + v.vectorBase.LongString(indent) + "\n}"
+}
@@ -0,0 +1,123 @@
+package vector_test
+
+import (
+	"testing"
+
+	"github.com/lleo/go-hamt/vector"
+)
+
+// numVals is enough values for a trie four tables deep.
+const numVals = 40000
+
+func buildVector(functional bool, n int) vector.Vector {
+	var v = vector.New(functional)
+	for i := 0; i < n; i++ {
+		v = v.Append(i)
+	}
+	return v
+}
+
+func checkVector(t *testing.T, name string, v vector.Vector, vals []int) {
+	if v.Len() != uint(len(vals)) {
+		t.Fatalf("%s: v.Len(),%d != %d", name, v.Len(), len(vals))
+	}
+	for i, expected := range vals {
+		var val, found = v.Get(uint(i))
+		if !found || val != expected {
+			t.Fatalf("%s: v.Get(%d) => %v, %t; expected %d",
+				name, i, val, found, expected)
+		}
+	}
+	if _, found := v.Get(uint(len(vals))); found {
+		t.Fatalf("%s: v.Get(%d) found a value past the end", name, len(vals))
+	}
+
+	var n int
+	v.Range(func(i uint, val interface{}) bool {
+		if i != uint(n) || val != vals[n] {
+			t.Fatalf("%s: v.Range() visited %d:%v; expected %d:%d",
+				name, i, val, n, vals[n])
+		}
+		n++
+		return true
+	})
+	if n != len(vals) {
+		t.Fatalf("%s: v.Range() visited %d values; expected %d",
+			name, n, len(vals))
+	}
+}
+
+func seq(from, to int) []int {
+	var vals = make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		vals = append(vals, i)
+	}
+	return vals
+}
+
+func TestVector(t *testing.T) {
+	for _, functional := range []bool{true, false} {
+		var name = "TestVector:transient"
+		if functional {
+			name = "TestVector:functional"
+		}
+
+		var v = buildVector(functional, numVals)
+		var vals = seq(0, numVals)
+		checkVector(t, name+":Append", v, vals)
+
+		var orig = v
+		if functional {
+			orig = v.DeepCopy()
+		}
+
+		for _, i := range []int{0, 31, 32, 1023, 1024, numVals - 1} {
+			var ok bool
+			v, ok = v.Set(uint(i), -i)
+			if !ok {
+				t.Fatalf("%s: v.Set(%d) failed", name, i)
+			}
+			vals[i] = -i
+		}
+		if _, ok := v.Set(numVals, 0); ok {
+			t.Fatalf("%s: v.Set(%d) past the end succeeded", name, numVals)
+		}
+		checkVector(t, name+":Set", v, vals)
+
+		// pop back down across the 32768 and 1024 boundaries
+		for len(vals) > 1000 {
+			var val interface{}
+			var ok bool
+			v, val, ok = v.Pop()
+			if !ok || val != vals[len(vals)-1] {
+				t.Fatalf("%s: v.Pop() => %v, %t; expected %d",
+					name, val, ok, vals[len(vals)-1])
+			}
+			vals = vals[:len(vals)-1]
+		}
+		checkVector(t, name+":Pop", v, vals)
+
+		checkVector(t, name+":Slice", v.Slice(100, 900), vals[100:900])
+
+		if functional {
+			checkVector(t, name+":persistent", orig, seq(0, numVals))
+		}
+	}
+}
+
+func TestVectorPopEmpty(t *testing.T) {
+	for _, functional := range []bool{true, false} {
+		var v = buildVector(functional, 33)
+		v = v.Slice(0, 1)
+		var val interface{}
+		var ok bool
+		v, val, ok = v.Pop()
+		if !ok || val != 0 || !v.IsEmpty() {
+			t.Fatalf("v.Pop() => %v, %t; v.Len()=%d", val, ok, v.Len())
+		}
+		if _, _, ok = v.Pop(); ok {
+			t.Fatal("v.Pop() of an empty Vector succeeded")
+		}
+		checkVector(t, "TestVectorPopEmpty:reuse", v.Append(7, 8), []int{7, 8})
+	}
+}
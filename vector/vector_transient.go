@@ -0,0 +1,111 @@
+package vector
+
+// VectorTransient is the data structure which the Transient Vector methods
+// are called upon. It is identical to the VectorFunctional data structure; it
+// is its own type so that the methods it calls are the transient versions of
+// the Vector interface.
+//
+// The Transient version of the Vector data structure does all modifications
+// in-place. So sharing this data structure between threads is NOT safe.
+type VectorTransient struct {
+	vectorBase
+}
+
+// NewTransient constructs a new, empty, VectorTransient data structure.
+func NewTransient() *VectorTransient {
+	return new(VectorTransient)
+}
+
+// IsEmpty simply returns if the VectorTransient data structure has no entries.
+func (v *VectorTransient) IsEmpty() bool {
+	return v.vectorBase.IsEmpty()
+}
+
+// Len returns the number of values in the VectorTransient data structure.
+func (v *VectorTransient) Len() uint {
+	return v.vectorBase.Len()
+}
+
+// ToFunctional just recasts the VectorTransient pointer to a VectorFunctional
+// underneath the Vector interface.
+//
+// If you want a copy of the VectorTransient data structure over to a
+// completely independent VectorFunctional data structure, you should first do
+// a DeepCopy followed by a ToFunctional call.
+func (v *VectorTransient) ToFunctional() Vector {
+	return (*VectorFunctional)(v)
+}
+
+// ToTransient does nothing to a VectorTransient pointer. This method is only
+// here for conformance with the Vector interface.
+func (v *VectorTransient) ToTransient() Vector {
+	return v
+}
+
+// DeepCopy copies the VectorTransient data structure and every table it
+// contains recursively.
+func (v *VectorTransient) DeepCopy() Vector {
+	var nv = new(VectorTransient)
+	nv.vectorBase = v.vectorBase.deepCopy()
+	return nv
+}
+
+// Get retrieves the value at index i. It also returns a bool which is false if
+// i is out of range.
+func (v *VectorTransient) Get(i uint) (interface{}, bool) {
+	return v.vectorBase.Get(i)
+}
+
+// Set replaces the value at index i in place. It returns the VectorTransient
+// and a bool which is false if i is out of range.
+func (v *VectorTransient) Set(i uint, val interface{}) (Vector, bool) {
+	if i >= v.length {
+		return v, false
+	}
+	v.set(i, val, false)
+	return v, true
+}
+
+// Append adds the values to the end of the VectorTransient in place and
+// returns it.
+func (v *VectorTransient) Append(vals ...interface{}) Vector {
+	for _, val := range vals {
+		v.append(val, false)
+	}
+	return v
+}
+
+// Pop removes the last value in place. It returns the VectorTransient, the
+// value removed, and true; or nil and false if the VectorTransient is empty.
+func (v *VectorTransient) Pop() (Vector, interface{}, bool) {
+	if v.IsEmpty() {
+		return v, nil, false
+	}
+	var val = v.pop(false)
+	return v, val, true
+}
+
+// Slice cuts the VectorTransient down to the values [from, to) in place and
+// returns it. Like slicing a Go slice, it panics if from > to or to > Len().
+func (v *VectorTransient) Slice(from, to uint) Vector {
+	v.slice(from, to, false)
+	return v
+}
+
+// Range executes the given function for every value in the VectorTransient in
+// index order. The iteration stops if fn returns false.
+func (v *VectorTransient) Range(fn func(uint, interface{}) bool) {
+	v.vectorBase.Range(fn)
+}
+
+// String returns a simple string representation of the VectorTransient data
+// structure.
+func (v *VectorTransient) String() string {
+	return "VectorTransient{" + v.vectorBase.String() + "}"
+}
+
+// LongString returns a complete recusive listing of the entire
+// VectorTransient data structure.
+func (v *VectorTransient) LongString(indent string) string {
+	return "VectorTransient{\n" + v.vectorBase.LongString(indent) + "\n}"
+}